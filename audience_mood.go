@@ -0,0 +1,232 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+type AudienceConfig struct {
+    Enabled   bool
+    Window    time.Duration // how far back chat counts
+    Contagion float64       // 0..1, how strongly chat's mood rubs off on the VTuber; 0 only observes
+    Interval  time.Duration // minimum time between contagion updates
+    HypeRate  float64       // messages per minute that count as a busy chat
+    TipWeight float64       // how much more a tip memo counts than a chat message
+}
+
+// AudienceMoodSnapshot is the aggregate mood of chat over the window
+type AudienceMoodSnapshot struct {
+    Messages  int       `json:"messages"`
+    Tips      int       `json:"tips"`
+    Emotion   string    `json:"emotion"` // taxonomy label nearest to the aggregate VAD
+    Valence   float64   `json:"valence"`
+    Arousal   float64   `json:"arousal"`
+    Dominance float64   `json:"dominance"`
+    Hype      float64   `json:"hype"`
+    Toxicity  float64   `json:"toxicity"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+type audienceSample struct {
+    at       time.Time
+    vad      VADValues
+    weight   float64
+    energy   float64
+    toxicity float64
+    tip      bool
+}
+
+// AudienceMood classifies every chat message and tip memo, not only the
+// ones that get answered, and lets chat's mood feed the VTuber's.
+type AudienceMood struct {
+    config     AudienceConfig
+    classifier *LexiconClassifier
+    engine     *EmotionEngine
+    moderation *ModerationPipeline
+    mu         sync.Mutex
+
+    samples       []audienceSample
+    lastContagion time.Time
+}
+
+func NewAudienceMood(config AudienceConfig, engine *EmotionEngine, moderation *ModerationPipeline) (*AudienceMood, error) {
+    if config.Window <= 0 {
+        config.Window = 2 * time.Minute
+    }
+    if config.Interval <= 0 {
+        config.Interval = 5 * time.Second
+    }
+    if config.HypeRate <= 0 {
+        config.HypeRate = 30
+    }
+    if config.TipWeight <= 0 {
+        config.TipWeight = 3
+    }
+    config.Contagion = clamp(config.Contagion, 0, 1)
+
+    // Every message is classified, so this stays offline
+    classifier, err := NewLexiconClassifier()
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize audience classifier: %w", err)
+    }
+
+    return &AudienceMood{
+        config:     config,
+        classifier: classifier,
+        engine:     engine,
+        moderation: moderation,
+    }, nil
+}
+
+// Observe adds a chat message or tip to the window. Samples are stamped
+// when they arrive, not with input.ReceivedAt, so the window stays in time
+// order even for late tips.
+func (a *AudienceMood) Observe(input ChatInput) {
+    sample := audienceSample{tip: input.Tip != nil, weight: 1}
+    if sample.tip {
+        sample.weight = a.config.TipWeight
+    }
+
+    sample.vad = getVADValues("neutral")
+    if text := strings.TrimSpace(input.Text); text != "" {
+        emotion, confidence, _ := a.classifier.Classify(context.Background(), text)
+        sample.vad = getVADValues(emotion)
+        sample.weight *= confidence
+
+        // Positive excitement makes hype, angry excitement doesn't
+        sample.energy = clamp((sample.vad.Arousal-0.5)*2, 0, 1)
+        if sample.vad.Valence < 0.5 {
+            sample.energy *= 0.5
+        }
+
+        // Hostility is unpleasant and dominant; moderation rules are certain
+        sample.toxicity = clamp((0.5-sample.vad.Valence)*2, 0, 1) * clamp((sample.vad.Dominance-0.5)*2, 0, 1) * confidence
+        if a.moderation != nil && a.moderation.MatchesRules(text) {
+            sample.toxicity = 1
+        }
+    }
+
+    a.mu.Lock()
+    now := time.Now()
+    sample.at = now
+    a.samples = append(a.samples, sample)
+    a.prune(now)
+    contagious := a.engine != nil && a.config.Contagion > 0 && now.Sub(a.lastContagion) >= a.config.Interval
+    if contagious {
+        a.lastContagion = now
+    }
+    snapshot := a.snapshot(now)
+    a.mu.Unlock()
+
+    // A hyped chat is more contagious than a quiet one
+    if contagious {
+        mood := VADValues{Valence: snapshot.Valence, Arousal: snapshot.Arousal, Dominance: snapshot.Dominance}
+        a.engine.Absorb(mood, a.config.Contagion*(0.5+0.5*snapshot.Hype))
+    }
+}
+
+// Snapshot returns the mood over the window as of now
+func (a *AudienceMood) Snapshot() AudienceMoodSnapshot {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    now := time.Now()
+    a.prune(now)
+    return a.snapshot(now)
+}
+
+// PromptLine describes chat's mood for the system prompt; empty when chat
+// has been quiet for the whole window
+func (a *AudienceMood) PromptLine() string {
+    snapshot := a.Snapshot()
+    if snapshot.Messages == 0 {
+        return ""
+    }
+
+    line := fmt.Sprintf("Chat's mood right now: mostly %s, hype %.2f, toxicity %.2f (0 to 1).",
+        snapshot.Emotion, snapshot.Hype, snapshot.Toxicity)
+    switch {
+    case snapshot.Toxicity >= 0.4:
+        line += " Chat is getting hostile, stay calm and don't feed it."
+    case snapshot.Hype >= 0.7:
+        line += " Chat is hyped, match their energy."
+    case snapshot.Hype <= 0.2:
+        line += " Chat is quiet, keep things going."
+    }
+    return line
+}
+
+// ServeHTTP serves the snapshot as JSON for the stream overlay
+func (a *AudienceMood) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    if err := json.NewEncoder(w).Encode(a.Snapshot()); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// Callers hold a.mu
+func (a *AudienceMood) prune(now time.Time) {
+    cutoff := now.Add(-a.config.Window)
+    drop := 0
+    for drop < len(a.samples) && a.samples[drop].at.Before(cutoff) {
+        drop++
+    }
+    a.samples = a.samples[drop:]
+}
+
+// Callers hold a.mu
+func (a *AudienceMood) snapshot(now time.Time) AudienceMoodSnapshot {
+    neutral := getVADValues("neutral")
+    snapshot := AudienceMoodSnapshot{
+        Emotion:   "neutral",
+        Valence:   neutral.Valence,
+        Arousal:   neutral.Arousal,
+        Dominance: neutral.Dominance,
+        UpdatedAt: now,
+    }
+    if len(a.samples) == 0 {
+        return snapshot
+    }
+
+    var vad VADValues
+    var totalWeight, energy, toxicity, count float64
+    for _, sample := range a.samples {
+        if sample.tip {
+            snapshot.Tips++
+        }
+        snapshot.Messages++
+
+        vad.Valence += sample.vad.Valence * sample.weight
+        vad.Arousal += sample.vad.Arousal * sample.weight
+        vad.Dominance += sample.vad.Dominance * sample.weight
+        totalWeight += sample.weight
+
+        counted := 1.0
+        if sample.tip {
+            counted = a.config.TipWeight
+        }
+        energy += sample.energy * counted
+        toxicity += sample.toxicity
+        count += counted
+    }
+    if totalWeight > 0 {
+        snapshot.Valence = vad.Valence / totalWeight
+        snapshot.Arousal = vad.Arousal / totalWeight
+        snapshot.Dominance = vad.Dominance / totalWeight
+    }
+    snapshot.Emotion, _ = nearestEmotion(VADValues{Valence: snapshot.Valence, Arousal: snapshot.Arousal, Dominance: snapshot.Dominance})
+
+    // Hype is how busy chat is and how excited it sounds
+    rate := count / a.config.Window.Minutes()
+    snapshot.Hype = 0.5*math.Min(1, rate/a.config.HypeRate) + 0.5*energy/count
+    snapshot.Toxicity = toxicity / float64(len(a.samples))
+
+    return snapshot
+}
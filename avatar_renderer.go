@@ -3,6 +3,7 @@ package main
 import (
     "context"
     "image"
+    "sort"
     "sync"
     "time"
 
@@ -82,18 +83,73 @@ func NewAvatarRenderer(config RenderConfig) (*AvatarRenderer, error) {
 }
 
 func (ar *AvatarRenderer) Update(emotion string, intensity float64) {
+    ar.UpdateBlend(emotion, "", 0, intensity)
+}
+
+// UpdateBlend mixes the expressions of two emotions, blend being the
+// secondary's share, instead of snapping from one to the other
+func (ar *AvatarRenderer) UpdateBlend(emotion string, secondary string, blend float64, intensity float64) {
     ar.mu.Lock()
     defer ar.mu.Unlock()
 
     // Update avatar state based on emotion
-    ar.updateExpression(emotion, intensity)
+    ar.updateExpression(blendExpressions(emotion, secondary, blend), intensity)
     ar.updateAnimation(emotion)
     ar.updatePhysics()
 }
 
-func (ar *AvatarRenderer) updateExpression(emotion string, intensity float64) {
+// FollowEmotions keeps the expression in step with the emotion engine's
+// events until ctx is done
+func (ar *AvatarRenderer) FollowEmotions(ctx context.Context, bus *EmotionBus) {
+    sub := bus.Subscribe(SubscribeOptions{
+        Types:  []EmotionEventType{EmotionChanged, MoodShifted},
+        Buffer: 4,
+        Policy: PolicyDropOldest,
+    })
+    defer sub.Unsubscribe()
+
+    for {
+        select {
+        case event, ok := <-sub.C:
+            if !ok {
+                return
+            }
+            state := event.State
+            ar.UpdateBlend(state.Primary, state.Secondary, state.Blend, state.Intensity)
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// PlayGesture switches to a named animation if the avatar has one
+func (ar *AvatarRenderer) PlayGesture(name string) bool {
+    ar.mu.Lock()
+    defer ar.mu.Unlock()
+
+    if _, ok := ar.animations[name]; !ok {
+        return false
+    }
+    ar.transitionAnimation(name, 200*time.Millisecond)
+    return true
+}
+
+// Gestures lists the animation names the model may ask for, sorted so the
+// prompt doesn't change between runs
+func (ar *AvatarRenderer) Gestures() []string {
+    ar.mu.RLock()
+    defer ar.mu.RUnlock()
+
+    names := make([]string, 0, len(ar.animations))
+    for name := range ar.animations {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func (ar *AvatarRenderer) updateExpression(baseExpr map[string]float64, intensity float64) {
     // Update facial expression parameters
-    baseExpr := getBaseExpression(emotion)
     for key, value := range baseExpr {
         current := ar.currentState.Expression[key]
         target := value * intensity
@@ -165,7 +221,32 @@ func lerp(a, b, t float64) float64 {
     return a + (b-a)*t
 }
 
+func blendExpressions(emotion string, secondary string, blend float64) map[string]float64 {
+    expression := getBaseExpression(emotion)
+    if secondary == "" || blend <= 0 {
+        return expression
+    }
+
+    blend = clamp(blend, 0, 1)
+    for key, value := range getBaseExpression(secondary) {
+        expression[key] = lerp(expression[key], value, blend)
+    }
+    return expression
+}
+
+// getBaseExpression returns the emotion's expression parameters from the
+// taxonomy. Parameters it doesn't set are zero so the previous emotion's
+// features relax.
 func getBaseExpression(emotion string) map[string]float64 {
-    // Return base expression parameters for given emotion
-    return nil
+    taxonomy := emotionTaxonomy()
+    expression := make(map[string]float64)
+    for _, name := range taxonomy.Names() {
+        for key := range taxonomy.Get(name).Expression {
+            expression[key] = 0
+        }
+    }
+    for key, value := range taxonomy.Get(emotion).Expression {
+        expression[key] = value
+    }
+    return expression
 } 
\ No newline at end of file
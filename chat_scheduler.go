@@ -0,0 +1,377 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "math"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+
+    "github.com/gagliardetto/solana-go"
+)
+
+var questionWords = []string{"what", "why", "how", "who", "when", "where", "which", "can", "could", "do", "does", "did", "is", "are", "will", "would", "should"}
+
+// ChatInput is a chat message waiting for an answer
+type ChatInput struct {
+    Viewer     ViewerIdentity
+    Text       string
+    ReceivedAt time.Time
+    Tip        *TipEvent
+}
+
+type SchedulerWeights struct {
+    Tip       float64
+    Mention   float64
+    Question  float64
+    FirstTime float64
+    Age       float64
+}
+
+type SchedulerConfig struct {
+    Weights       SchedulerWeights
+    MaxAge        time.Duration      // untipped messages older than this are dropped
+    MaxQueue      int                // lowest scored message is dropped when full
+    MentionNames  []string           // names that count as addressing the VTuber
+    TipTierScores map[string]float64 // RewardTier -> 0..1, otherwise scaled by amount
+    TipWindow     time.Duration      // how long a tip boosts the sender's next message
+}
+
+type SchedulerMetrics struct {
+    QueueDepth      int
+    Submitted       int64
+    Answered        int64
+    DroppedStale    int64
+    DroppedOverflow int64
+}
+
+type queuedInput struct {
+    input     ChatInput
+    firstTime bool
+}
+
+// ChatScheduler sits in front of LLMProcessor and decides which pending chat
+// message gets answered next, so a flood of chat doesn't bury tippers and
+// direct questions behind whatever arrived first.
+type ChatScheduler struct {
+    config  SchedulerConfig
+    mu      sync.Mutex
+    notify  chan struct{}
+
+    queue      []queuedInput
+    seen       map[string]time.Time
+    recentTips map[string]TipEvent
+    metrics    SchedulerMetrics
+    audience   *AudienceMood
+    profiles   *ViewerProfiles
+}
+
+func NewChatScheduler(config SchedulerConfig) *ChatScheduler {
+    if config.Weights == (SchedulerWeights{}) {
+        config.Weights = SchedulerWeights{Tip: 3, Mention: 1.5, Question: 1, FirstTime: 0.8, Age: 0.5}
+    }
+    if config.MaxAge <= 0 {
+        config.MaxAge = 2 * time.Minute
+    }
+    if config.MaxQueue <= 0 {
+        config.MaxQueue = 200
+    }
+    if config.TipWindow <= 0 {
+        config.TipWindow = time.Minute
+    }
+
+    return &ChatScheduler{
+        config:     config,
+        notify:     make(chan struct{}, 1),
+        seen:       make(map[string]time.Time),
+        recentTips: make(map[string]TipEvent),
+    }
+}
+
+func (s *ChatScheduler) Submit(input ChatInput) {
+    if strings.TrimSpace(input.Text) == "" {
+        return
+    }
+    if input.ReceivedAt.IsZero() {
+        input.ReceivedAt = time.Now()
+    }
+
+    // Chat's mood counts every message, answered or not
+    if audience := s.audienceMood(); audience != nil {
+        audience.Observe(input)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.metrics.Submitted++
+
+    key := input.Viewer.Key()
+    if input.Tip == nil && input.Viewer.Wallet != "" {
+        if tip, ok := s.recentTips[input.Viewer.Wallet]; ok && time.Since(tip.Timestamp) < s.config.TipWindow {
+            input.Tip = &tip
+            delete(s.recentTips, input.Viewer.Wallet)
+        }
+    }
+
+    queued := queuedInput{input: input}
+    if key != "" {
+        if _, ok := s.seen[key]; !ok {
+            queued.firstTime = true
+        }
+        s.seen[key] = input.ReceivedAt
+    }
+
+    s.queue = append(s.queue, queued)
+    if len(s.queue) > s.config.MaxQueue {
+        s.dropLowest(time.Now())
+    }
+
+    select {
+    case s.notify <- struct{}{}:
+    default:
+    }
+}
+
+// SubmitTip queues a tip's message, or boosts the sender's pending and next
+// chat message when the tip came without one.
+func (s *ChatScheduler) SubmitTip(tip TipEvent) {
+    wallet := tip.Sender.String()
+    if profiles := s.viewerProfiles(); profiles != nil {
+        profiles.RecordTip(tip)
+    }
+    if tip.Message != "" {
+        s.Submit(ChatInput{
+            Viewer: ViewerIdentity{Wallet: wallet},
+            Text:   tip.Message,
+            Tip:    &tip,
+        })
+        return
+    }
+
+    if audience := s.audienceMood(); audience != nil {
+        audience.Observe(ChatInput{Viewer: ViewerIdentity{Wallet: wallet}, Tip: &tip})
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i := range s.queue {
+        if s.queue[i].input.Viewer.Wallet == wallet && s.queue[i].input.Tip == nil {
+            s.queue[i].input.Tip = &tip
+            return
+        }
+    }
+    s.recentTips[wallet] = tip
+}
+
+// SetAudienceMood feeds every submitted message and tip to the audience
+// mood tracker
+func (s *ChatScheduler) SetAudienceMood(audience *AudienceMood) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.audience = audience
+}
+
+func (s *ChatScheduler) audienceMood() *AudienceMood {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.audience
+}
+
+// SetViewerProfiles adds tips to the sender's profile. Viewers are observed
+// when their message is answered, by the LLM processor.
+func (s *ChatScheduler) SetViewerProfiles(profiles *ViewerProfiles) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.profiles = profiles
+}
+
+func (s *ChatScheduler) viewerProfiles() *ViewerProfiles {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.profiles
+}
+
+// Next blocks until a message is ready and returns the highest scored one
+func (s *ChatScheduler) Next(ctx context.Context) (ChatInput, error) {
+    for {
+        if input, ok := s.pop(time.Now()); ok {
+            return input, nil
+        }
+
+        select {
+        case <-s.notify:
+        case <-ctx.Done():
+            return ChatInput{}, ctx.Err()
+        }
+    }
+}
+
+// Run answers queued messages one at a time until ctx is done
+func (s *ChatScheduler) Run(ctx context.Context, llm *LLMProcessor, handle func(ChatInput, *Response)) error {
+    if audience := llm.AudienceMood(); audience != nil {
+        s.SetAudienceMood(audience)
+    }
+    s.SetViewerProfiles(llm.ViewerProfiles())
+
+    for {
+        input, err := s.Next(ctx)
+        if err != nil {
+            return err
+        }
+
+        response, err := llm.ProcessInput(ctx, input.Viewer, input.Text)
+        if err != nil {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            log.Printf("Failed to answer %s: %v", input.Viewer.DisplayName(), err)
+            continue
+        }
+        handle(input, response)
+    }
+}
+
+func (s *ChatScheduler) pop(now time.Time) (ChatInput, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.dropStale(now)
+    if len(s.queue) == 0 {
+        return ChatInput{}, false
+    }
+
+    best := 0
+    bestScore := s.score(s.queue[0], now)
+    for i := 1; i < len(s.queue); i++ {
+        if score := s.score(s.queue[i], now); score > bestScore {
+            best, bestScore = i, score
+        }
+    }
+
+    input := s.queue[best].input
+    s.queue = append(s.queue[:best], s.queue[best+1:]...)
+    s.metrics.Answered++
+    return input, true
+}
+
+func (s *ChatScheduler) score(queued queuedInput, now time.Time) float64 {
+    weights := s.config.Weights
+    input := queued.input
+
+    score := 0.0
+    if input.Tip != nil {
+        score += weights.Tip * s.tipScore(*input.Tip)
+    }
+    if s.isMention(input.Text) {
+        score += weights.Mention
+    }
+    if isQuestion(input.Text) {
+        score += weights.Question
+    }
+    if queued.firstTime {
+        score += weights.FirstTime
+    }
+
+    // Older messages slowly catch up so nobody waits forever
+    age := now.Sub(input.ReceivedAt).Seconds() / s.config.MaxAge.Seconds()
+    score += weights.Age * math.Min(age, 1)
+
+    return score
+}
+
+func (s *ChatScheduler) tipScore(tip TipEvent) float64 {
+    if score, ok := s.config.TipTierScores[tip.RewardTier]; ok {
+        return score
+    }
+    // Any tip counts for something, a full SOL or more counts fully
+    return math.Min(1, 0.2+float64(tip.Amount)/float64(solana.LAMPORTS_PER_SOL))
+}
+
+func (s *ChatScheduler) isMention(text string) bool {
+    lower := strings.ToLower(text)
+    for _, name := range s.config.MentionNames {
+        if name != "" && strings.Contains(lower, strings.ToLower(name)) {
+            return true
+        }
+    }
+    return false
+}
+
+func isQuestion(text string) bool {
+    text = strings.TrimSpace(text)
+    if strings.ContainsAny(text, "?？") {
+        return true
+    }
+
+    first := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && r != '\''
+    })
+    return len(first) > 0 && containsString(questionWords, first[0])
+}
+
+// Tipped messages are never dropped as stale, the viewer paid for an answer
+func (s *ChatScheduler) dropStale(now time.Time) {
+    kept := s.queue[:0]
+    for _, queued := range s.queue {
+        if queued.input.Tip == nil && now.Sub(queued.input.ReceivedAt) > s.config.MaxAge {
+            s.metrics.DroppedStale++
+            continue
+        }
+        kept = append(kept, queued)
+    }
+    s.queue = kept
+
+    for wallet, tip := range s.recentTips {
+        if now.Sub(tip.Timestamp) > s.config.TipWindow {
+            delete(s.recentTips, wallet)
+        }
+    }
+}
+
+func (s *ChatScheduler) dropLowest(now time.Time) {
+    lowest := 0
+    lowestScore := s.score(s.queue[0], now)
+    for i := 1; i < len(s.queue); i++ {
+        if score := s.score(s.queue[i], now); score < lowestScore {
+            lowest, lowestScore = i, score
+        }
+    }
+
+    s.queue = append(s.queue[:lowest], s.queue[lowest+1:]...)
+    s.metrics.DroppedOverflow++
+}
+
+func (s *ChatScheduler) QueueDepth() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return len(s.queue)
+}
+
+func (s *ChatScheduler) Metrics() SchedulerMetrics {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    metrics := s.metrics
+    metrics.QueueDepth = len(s.queue)
+    return metrics
+}
+
+// Health reports a backed up queue to the stream health check
+func (s *ChatScheduler) Health() HealthStatus {
+    depth := s.QueueDepth()
+    return HealthStatus{
+        Healthy:  depth < s.config.MaxQueue/2,
+        Degraded: depth >= s.config.MaxQueue/2,
+        Detail:   fmt.Sprintf("%d messages queued", depth),
+    }
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:          "makimo",
+		Short:        "Makimo.Live AI VTuber",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			runStream()
+		},
+	}
+	root.AddCommand(newRunCommand())
+	root.AddCommand(newReplayCommand())
+	root.AddCommand(newMemoryCommand())
+	return root
+}
+
+// newRunCommand streams with the Go-style flags parseFlags reads, e.g.
+// makimo run -config foo.json
+func newRunCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                "run [stream flags]",
+		Short:              "Go live",
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			// parseFlags reads os.Args, which still starts with "run"
+			os.Args = append([]string{os.Args[0]}, args...)
+			runStream()
+		},
+	}
+}
+
+func newReplayCommand() *cobra.Command {
+	var (
+		speed    float64
+		emotions string
+		assets   string
+		width    int
+		height   int
+		fps      int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replay <timeline.jsonl>",
+		Short: "Replay a recorded emotion timeline against the avatar and voice modifiers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Replaying with an edited taxonomy is how expressions get tuned
+			if emotions != "" {
+				taxonomy, err := LoadEmotionTaxonomy(emotions)
+				if err != nil {
+					return err
+				}
+				SetEmotionTaxonomy(taxonomy)
+			}
+
+			records, err := ReadEmotionTimeline(args[0])
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			replay := &EmotionReplay{
+				Speed: speed,
+				OnRecord: func(record EmotionRecord, modifier VoiceModifier) {
+					fmt.Fprintf(out, "%s %-10s %-10s %.2f %-8s V%.2f A%.2f D%.2f pitch %+.1f rate %+.2f volume %+.1f\n",
+						record.Timestamp.Format("15:04:05.000"), record.Emotion, record.Secondary, record.Blend, record.Source,
+						record.VAD.Valence, record.VAD.Arousal, record.VAD.Dominance,
+						modifier.PitchMod, modifier.RateMod, modifier.VolumeMod)
+				},
+			}
+			if assets != "" {
+				replay.Avatar, err = NewAvatarRenderer(RenderConfig{
+					AssetPath:  assets,
+					Resolution: image.Pt(width, height),
+					FrameRate:  fps,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to initialize avatar: %w", err)
+				}
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			fmt.Fprintf(out, "Replaying %d emotion records at %gx\n", len(records), speed)
+			return replay.Run(ctx, records)
+		},
+	}
+
+	cmd.Flags().Float64Var(&speed, "speed", 1, "playback speed, 1 for real time")
+	cmd.Flags().StringVar(&emotions, "emotions", "", "emotion taxonomy file to replay with instead of the built-in one")
+	cmd.Flags().StringVar(&assets, "assets", "", "avatar asset directory; without it only voice modifiers are shown")
+	cmd.Flags().IntVar(&width, "width", 1920, "avatar width")
+	cmd.Flags().IntVar(&height, "height", 1080, "avatar height")
+	cmd.Flags().IntVar(&fps, "fps", 30, "avatar frame rate")
+	return cmd
+}
+
+// newMemoryCommand fixes what the VTuber remembers between streams. It
+// works on the persisted memory directory, so run it while the stream is
+// down.
+func newMemoryCommand() *cobra.Command {
+	var (
+		configPath string
+		dir        string
+		embedder   EmbedderConfig
+		mb         *MemoryBuffer
+	)
+
+	cmd := &cobra.Command{
+		Use:   "memory",
+		Short: "List, search, edit, pin and forget long-term memories",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var config MemoryConfig
+			if configPath != "" {
+				ai, err := loadAIConfig(configPath)
+				if err != nil {
+					return err
+				}
+				config = ai.Memory
+			}
+			if dir != "" {
+				config.Path = dir
+			}
+			if config.Path == "" {
+				return fmt.Errorf("no memory directory, pass --config or --dir")
+			}
+			flags := cmd.Flags()
+			if flags.Changed("embedder") {
+				config.Embedder.Type = embedder.Type
+			}
+			if flags.Changed("embedding-model") {
+				config.Embedder.Model = embedder.Model
+			}
+			if flags.Changed("embedding-url") {
+				config.Embedder.BaseURL = embedder.BaseURL
+			}
+			// Only forget deletes, the stream's own limit evicts on its next run
+			config.MaxLongTerm = -1
+
+			var err error
+			mb, err = NewMemoryBuffer(config)
+			if err != nil {
+				return err
+			}
+			if cmd.Name() == "search" || cmd.Name() == "edit" {
+				e, err := NewEmbedder(config.Embedder, os.Getenv("OPENAI_API_KEY"), nil)
+				if err != nil {
+					return err
+				}
+				// Re-embedding is left to the stream, which knows its embedder
+				mb.UseEmbedder(e)
+			}
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			return mb.Close()
+		},
+	}
+	cmd.PersistentFlags().StringVar(&configPath, "config", "", "JSON file with the stream's AI settings, for its Memory directory and embedder")
+	cmd.PersistentFlags().StringVar(&dir, "dir", "", "memory directory, overrides the one in --config")
+	cmd.PersistentFlags().StringVar(&embedder.Type, "embedder", "hashing", "embedder for search and edits: hashing or openai (key from OPENAI_API_KEY)")
+	cmd.PersistentFlags().StringVar(&embedder.Model, "embedding-model", "", "embedding model for the openai embedder")
+	cmd.PersistentFlags().StringVar(&embedder.BaseURL, "embedding-url", "", "base URL of an OpenAI-compatible embeddings server")
+
+	var (
+		filter MemoryFilter
+		since  string
+		until  string
+	)
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List memories by type, importance or date",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if filter.Since, err = parseMemoryDate(since); err != nil {
+				return err
+			}
+			if filter.Until, err = parseMemoryDate(until); err != nil {
+				return err
+			}
+
+			memories, err := mb.List(filter)
+			if err != nil {
+				return err
+			}
+			printMemories(cmd.OutOrStdout(), memories)
+			return nil
+		},
+	}
+	list.Flags().StringVar(&filter.Type, "type", "", "only memories of this type, e.g. episode")
+	list.Flags().Float64Var(&filter.MinImportance, "min-importance", 0, "only memories at least this important")
+	list.Flags().StringVar(&since, "since", "", "only memories from this date (YYYY-MM-DD) on")
+	list.Flags().StringVar(&until, "until", "", "only memories before this date (YYYY-MM-DD)")
+	list.Flags().BoolVar(&filter.PinnedOnly, "pinned", false, "only pinned memories")
+	list.Flags().StringVar(&filter.SortBy, "sort", "date", "date, importance or type")
+	list.Flags().IntVar(&filter.Limit, "limit", 0, "at most this many memories")
+
+	var searchLimit int
+	search := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search memories ranked the way the VTuber recalls them",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			memories := mb.Search(cmd.Context(), strings.Join(args, " "), searchLimit)
+			printMemories(cmd.OutOrStdout(), memories)
+			return nil
+		},
+	}
+	search.Flags().IntVar(&searchLimit, "limit", 10, "at most this many memories")
+
+	var (
+		content    string
+		importance float64
+	)
+	edit := &cobra.Command{
+		Use:   "edit <id>",
+		Short: "Correct a memory's content or importance",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newContent *string
+			var newImportance *float64
+			if cmd.Flags().Changed("content") {
+				newContent = &content
+			}
+			if cmd.Flags().Changed("importance") {
+				newImportance = &importance
+			}
+			if newContent == nil && newImportance == nil {
+				return fmt.Errorf("nothing to change, pass --content or --importance")
+			}
+
+			memory, err := mb.Edit(cmd.Context(), args[0], newContent, newImportance)
+			if err != nil {
+				return err
+			}
+			printMemories(cmd.OutOrStdout(), []Memory{memory})
+			return nil
+		},
+	}
+	edit.Flags().StringVar(&content, "content", "", "new content")
+	edit.Flags().Float64Var(&importance, "importance", 0, "new importance, 0 to 1")
+
+	var unpin bool
+	pin := &cobra.Command{
+		Use:   "pin <id>...",
+		Short: "Pin memories so they never decay or get evicted",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				if err := mb.Pin(id, !unpin); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	pin.Flags().BoolVar(&unpin, "unpin", false, "unpin instead")
+
+	forget := &cobra.Command{
+		Use:   "forget <id>...",
+		Short: "Delete memories along with their associations",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				if err := mb.Forget(id); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Forgot %s\n", id)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(list, search, edit, pin, forget)
+	return cmd
+}
+
+// loadAIConfig reads AI settings saved as JSON, field names as in AIConfig
+func loadAIConfig(path string) (AIConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AIConfig{}, fmt.Errorf("failed to read config: %w", err)
+	}
+	var config AIConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return AIConfig{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return config, nil
+}
+
+func parseMemoryDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+	}
+	return date, nil
+}
+
+func printMemories(out io.Writer, memories []Memory) {
+	for _, memory := range memories {
+		pinned := " "
+		if memory.Pinned {
+			pinned = "*"
+		}
+		content := strings.Join(strings.Fields(memory.Content), " ")
+		if runes := []rune(content); len(runes) > 80 {
+			content = string(runes[:79]) + "…"
+		}
+		fmt.Fprintf(out, "%s %s %-12s %.2f %s  %s\n",
+			memory.ID, pinned, memory.Type, memory.Importance, memory.Timestamp.Format("2006-01-02 15:04"), content)
+	}
+	if len(memories) == 0 {
+		fmt.Fprintln(out, "No memories")
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+    "unicode"
+)
+
+// Chat formats add a few tokens of framing around every message
+const messageTokenOverhead = 4
+
+// Anything smaller than this is not worth keeping as a truncated fragment
+const minTruncatedTokens = 16
+
+// Share of the prompt that history keeps when the fixed caps don't fit
+const minHistoryShare = 0.25
+
+type Tokenizer interface {
+    CountTokens(text string) int
+}
+
+// ApproxTokenizer estimates BPE token counts without shipping a vocabulary.
+// Latin words cost roughly one token per four characters, while CJK
+// characters, punctuation and symbols cost about one token each.
+type ApproxTokenizer struct{}
+
+func (ApproxTokenizer) CountTokens(text string) int {
+    tokens := 0
+    wordLen := 0
+
+    flushWord := func() {
+        if wordLen > 0 {
+            tokens += (wordLen + 3) / 4
+            wordLen = 0
+        }
+    }
+
+    for _, r := range text {
+        switch {
+        case unicode.IsSpace(r):
+            flushWord()
+        case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+            flushWord()
+            tokens++
+        case unicode.IsLetter(r) || unicode.IsDigit(r):
+            wordLen++
+        case r > unicode.MaxLatin1:
+            // Emoji and other symbols usually take several byte-level tokens
+            flushWord()
+            tokens += 2
+        default:
+            flushWord()
+            tokens++
+        }
+    }
+    flushWord()
+
+    return tokens
+}
+
+type ContextBudgetConfig struct {
+    MaxTokens     int // model context window
+    ReplyTokens   int // reserved for the completion
+    SystemTokens  int // cap for the personality prompt
+    SummaryTokens int // cap for the running conversation summary
+    ThreadTokens  int // cap for the speaking viewer's earlier exchanges
+    ProfileTokens int // cap for what is known about the speaking viewer
+    MemoryTokens  int // cap for recalled memories
+    HistoryTokens int // cap for recent turns
+}
+
+// ContextParts are the pieces a prompt is assembled from. Summary, Thread
+// and Profile are optional and skipped when their content is empty.
+type ContextParts struct {
+    System   Message
+    Summary  Message
+    Thread   Message
+    Profile  Message
+    Memories []Message
+    History  []Message
+    Input    Message
+}
+
+// ContextUsage reports how many tokens each part of the prompt used
+type ContextUsage struct {
+    System          int
+    Summary         int
+    Thread          int
+    Profile         int
+    Memories        int
+    History         int
+    Input           int
+    Total           int
+    DroppedMessages int
+    Truncated       int
+}
+
+type ContextBudget struct {
+    tokenizer Tokenizer
+    config    ContextBudgetConfig
+}
+
+func NewContextBudget(config ContextBudgetConfig, tokenizer Tokenizer) *ContextBudget {
+    if config.MaxTokens <= 0 {
+        config.MaxTokens = 4096
+    }
+    if config.ReplyTokens <= 0 {
+        config.ReplyTokens = 1000
+    }
+    if config.ReplyTokens >= config.MaxTokens {
+        config.ReplyTokens = config.MaxTokens / 4
+    }
+    if config.SystemTokens <= 0 {
+        config.SystemTokens = 800
+    }
+    if config.SummaryTokens <= 0 {
+        config.SummaryTokens = 300
+    }
+    if config.ThreadTokens <= 0 {
+        config.ThreadTokens = 300
+    }
+    if config.ProfileTokens <= 0 {
+        config.ProfileTokens = 200
+    }
+    if config.MemoryTokens <= 0 {
+        config.MemoryTokens = 600
+    }
+    if config.HistoryTokens <= 0 {
+        config = config.fitCaps()
+    }
+    if tokenizer == nil {
+        tokenizer = ApproxTokenizer{}
+    }
+
+    return &ContextBudget{
+        tokenizer: tokenizer,
+        config:    config,
+    }
+}
+
+// fitCaps gives history what the fixed caps leave of the prompt. Small
+// context windows scale the fixed caps down proportionally so history
+// keeps at least minHistoryShare.
+func (c ContextBudgetConfig) fitCaps() ContextBudgetConfig {
+    prompt := c.MaxTokens - c.ReplyTokens
+    fixed := []*int{&c.SystemTokens, &c.SummaryTokens, &c.ThreadTokens, &c.ProfileTokens, &c.MemoryTokens}
+
+    total := 0
+    for _, size := range fixed {
+        total += *size
+    }
+    if limit := int(float64(prompt) * (1 - minHistoryShare)); total > limit {
+        scale := float64(limit) / float64(total)
+        total = 0
+        for _, size := range fixed {
+            *size = int(float64(*size) * scale)
+            total += *size
+        }
+    }
+
+    c.HistoryTokens = prompt - total
+    return c
+}
+
+func (b *ContextBudget) ReplyTokens() int {
+    return b.config.ReplyTokens
+}
+
+func (b *ContextBudget) SummaryTokens() int {
+    return b.config.SummaryTokens
+}
+
+func (b *ContextBudget) MessageTokens(msg Message) int {
+    return b.tokenizer.CountTokens(msg.Content) + messageTokenOverhead
+}
+
+// Allocate fits the prompt parts into the budget. The input, system prompt
+// and summary are always kept (truncated if they must be), recent turns come
+// next newest first a whole exchange at a time, and recalled memories get
+// what is left, so they are the first to be dropped.
+func (b *ContextBudget) Allocate(parts ContextParts) ([]Message, ContextUsage) {
+    var usage ContextUsage
+    remaining := b.config.MaxTokens - b.config.ReplyTokens
+    memories, history := parts.Memories, parts.History
+
+    // Current input
+    input, inputTokens := b.fit(parts.Input, remaining/2, &usage)
+    usage.Input = inputTokens
+    remaining -= usage.Input
+
+    // Personality prompt
+    system, systemTokens := b.fit(parts.System, minInt(b.config.SystemTokens, remaining), &usage)
+    usage.System = systemTokens
+    remaining -= usage.System
+
+    // Running summary of evicted turns
+    summary := parts.Summary
+    if summary.Content != "" {
+        summary, usage.Summary = b.fit(summary, minInt(b.config.SummaryTokens, remaining), &usage)
+        remaining -= usage.Summary
+    }
+
+    // What the current speaker said before the shared window
+    thread := parts.Thread
+    if thread.Content != "" {
+        thread, usage.Thread = b.fit(thread, minInt(b.config.ThreadTokens, remaining), &usage)
+        remaining -= usage.Thread
+    }
+
+    // What is known about the current speaker
+    profile := parts.Profile
+    if profile.Content != "" {
+        profile, usage.Profile = b.fit(profile, minInt(b.config.ProfileTokens, remaining), &usage)
+        remaining -= usage.Profile
+    }
+
+    // A reply is only kept together with the message it answers
+    historyLimit := minInt(b.config.HistoryTokens, remaining)
+    keptHistory := make([]Message, 0, len(history))
+    for end := len(history); end > 0; {
+        start := exchangeStart(history, end)
+        cost := 0
+        for _, msg := range history[start:end] {
+            cost += b.MessageTokens(msg)
+        }
+        if usage.History+cost > historyLimit {
+            usage.DroppedMessages += end
+            break
+        }
+        for i := end - 1; i >= start; i-- {
+            keptHistory = append(keptHistory, history[i])
+        }
+        usage.History += cost
+        end = start
+    }
+    reverseMessages(keptHistory)
+    remaining -= usage.History
+
+    // Memories arrive ranked, so keep them in order until the budget runs out
+    memoryLimit := minInt(b.config.MemoryTokens, remaining)
+    keptMemories := make([]Message, 0, len(memories))
+    for i, memory := range memories {
+        cost := b.MessageTokens(memory)
+        if usage.Memories+cost > memoryLimit {
+            kept := i
+            if room := memoryLimit - usage.Memories; room >= minTruncatedTokens {
+                memory, cost = b.fit(memory, room, &usage)
+                keptMemories = append(keptMemories, memory)
+                usage.Memories += cost
+                kept++
+            }
+            usage.DroppedMessages += len(memories) - kept
+            break
+        }
+        keptMemories = append(keptMemories, memory)
+        usage.Memories += cost
+    }
+
+    messages := make([]Message, 0, len(keptMemories)+len(keptHistory)+5)
+    messages = append(messages, system)
+    if summary.Content != "" {
+        messages = append(messages, summary)
+    }
+    if thread.Content != "" {
+        messages = append(messages, thread)
+    }
+    if profile.Content != "" {
+        messages = append(messages, profile)
+    }
+    messages = append(messages, keptMemories...)
+    messages = append(messages, keptHistory...)
+    messages = append(messages, input)
+
+    usage.Total = usage.Input + usage.System + usage.Summary + usage.Thread + usage.Profile + usage.History + usage.Memories
+    return messages, usage
+}
+
+// TrimHistory drops the oldest turns until history fits its token cap and
+// returns both the kept and the evicted messages.
+func (b *ContextBudget) TrimHistory(history []Message) ([]Message, []Message) {
+    total := 0
+    for _, msg := range history {
+        total += b.MessageTokens(msg)
+    }
+
+    cut := 0
+    for cut < len(history) && total > b.config.HistoryTokens {
+        total -= b.MessageTokens(history[cut])
+        cut++
+    }
+    // Don't keep a reply whose message was evicted
+    if cut > 0 && cut < len(history) && exchangeStart(history, cut+1) < cut {
+        cut++
+    }
+
+    return history[cut:], history[:cut]
+}
+
+// exchangeStart returns where the exchange ending at history[end-1] starts:
+// one message earlier for a reply to a viewer message, otherwise end-1
+func exchangeStart(history []Message, end int) int {
+    start := end - 1
+    if start > 0 && history[start].Role == "assistant" && history[start-1].Role != "assistant" {
+        start--
+    }
+    return start
+}
+
+func (b *ContextBudget) fit(msg Message, limit int, usage *ContextUsage) (Message, int) {
+    cost := b.MessageTokens(msg)
+    if cost <= limit {
+        return msg, cost
+    }
+
+    msg.Content = truncateToTokens(b.tokenizer, msg.Content, limit-messageTokenOverhead)
+    usage.Truncated++
+    return msg, b.MessageTokens(msg)
+}
+
+// truncateToTokens keeps the longest prefix of text that fits in limit tokens
+func truncateToTokens(tokenizer Tokenizer, text string, limit int) string {
+    if limit <= 0 {
+        return ""
+    }
+
+    runes := []rune(text)
+    lo, hi := 0, len(runes)
+    for lo < hi {
+        mid := (lo + hi + 1) / 2
+        if tokenizer.CountTokens(string(runes[:mid])) <= limit {
+            lo = mid
+        } else {
+            hi = mid - 1
+        }
+    }
+
+    return string(runes[:lo])
+}
+
+func reverseMessages(messages []Message) {
+    for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+        messages[i], messages[j] = messages[j], messages[i]
+    }
+}
+
+func minInt(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+// wordTokenizer makes token counts easy to reason about: one per word
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) int {
+    return len(strings.Fields(text))
+}
+
+func words(n int) string {
+    return strings.TrimSpace(strings.Repeat("word ", n))
+}
+
+// messages alternates viewer messages and replies, starting with a viewer
+func messages(count int, size int) []Message {
+    msgs := make([]Message, count)
+    for i := range msgs {
+        role := "user"
+        if i%2 == 1 {
+            role = "assistant"
+        }
+        msgs[i] = Message{Role: role, Content: words(size)}
+    }
+    return msgs
+}
+
+func TestApproxTokenizer(t *testing.T) {
+    tests := []struct {
+        text string
+        want int
+    }{
+        {"", 0},
+        {"hi", 1},
+        {"hello", 2},
+        {"hello, chat", 4},
+        {"こんにちは", 5},
+        {"🎉", 2},
+    }
+
+    for _, tt := range tests {
+        if got := (ApproxTokenizer{}).CountTokens(tt.text); got != tt.want {
+            t.Errorf("CountTokens(%q) = %d, want %d", tt.text, got, tt.want)
+        }
+    }
+}
+
+func TestContextBudgetCaps(t *testing.T) {
+    tests := []struct {
+        name        string
+        config      ContextBudgetConfig
+        wantReply   int
+        wantHistory int
+    }{
+        {name: "defaults", config: ContextBudgetConfig{}, wantReply: 1000, wantHistory: 4096 - 1000 - 2200},
+        {name: "explicit history", config: ContextBudgetConfig{HistoryTokens: 500}, wantReply: 1000, wantHistory: 500},
+        {name: "reply larger than window", config: ContextBudgetConfig{MaxTokens: 2000, ReplyTokens: 3000}, wantReply: 500, wantHistory: 377},
+        {name: "small window", config: ContextBudgetConfig{MaxTokens: 2048, ReplyTokens: 1000}, wantReply: 1000, wantHistory: 264},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := NewContextBudget(tt.config, nil).config
+            if c.ReplyTokens != tt.wantReply {
+                t.Errorf("reply tokens = %d, want %d", c.ReplyTokens, tt.wantReply)
+            }
+            if c.HistoryTokens != tt.wantHistory {
+                t.Errorf("history tokens = %d, want %d", c.HistoryTokens, tt.wantHistory)
+            }
+
+            prompt := c.MaxTokens - c.ReplyTokens
+            fixed := c.SystemTokens + c.SummaryTokens + c.ThreadTokens + c.ProfileTokens + c.MemoryTokens
+            if tt.config.HistoryTokens <= 0 && fixed+c.HistoryTokens != prompt {
+                t.Errorf("caps add up to %d, prompt is %d", fixed+c.HistoryTokens, prompt)
+            }
+            if c.HistoryTokens < int(float64(prompt)*minHistoryShare) && tt.config.HistoryTokens <= 0 {
+                t.Errorf("history gets %d of %d prompt tokens", c.HistoryTokens, prompt)
+            }
+        })
+    }
+}
+
+func TestContextBudgetAllocate(t *testing.T) {
+    // 80 prompt tokens: 50 for the fixed parts, 30 for history. Messages
+    // cost their words plus messageTokenOverhead.
+    config := ContextBudgetConfig{
+        MaxTokens:     100,
+        ReplyTokens:   20,
+        SystemTokens:  10,
+        SummaryTokens: 10,
+        ThreadTokens:  10,
+        ProfileTokens: 10,
+        MemoryTokens:  10,
+    }
+
+    tests := []struct {
+        name          string
+        parts         ContextParts
+        wantHistory   int // messages kept
+        wantMemories  int
+        wantDropped   int
+        wantTruncated int
+    }{
+        {
+            name:         "everything fits",
+            parts:        ContextParts{History: messages(2, 2), Memories: messages(1, 2)},
+            wantHistory:  2,
+            wantMemories: 1,
+        },
+        {
+            name:         "oldest exchange dropped whole",
+            parts:        ContextParts{History: messages(6, 2), Memories: messages(1, 2)},
+            wantHistory:  4,
+            wantMemories: 1,
+            wantDropped:  2,
+        },
+        {
+            name: "memories dropped before history",
+            parts: ContextParts{
+                System:   Message{Role: "system", Content: words(6)},
+                Summary:  Message{Content: words(30)},
+                Thread:   Message{Content: words(30)},
+                Profile:  Message{Content: words(30)},
+                History:  messages(5, 2),
+                Memories: messages(1, 2),
+            },
+            wantHistory:   5,
+            wantDropped:   1,
+            wantTruncated: 3,
+        },
+        {
+            name:         "memories past their cap dropped",
+            parts:        ContextParts{History: messages(1, 2), Memories: messages(3, 2)},
+            wantHistory:  1,
+            wantMemories: 1,
+            wantDropped:  2,
+        },
+        {
+            name:          "long system prompt truncated",
+            parts:         ContextParts{System: Message{Role: "system", Content: words(20)}, History: messages(1, 2)},
+            wantHistory:   1,
+            wantTruncated: 1,
+        },
+        {
+            name:          "optional parts capped",
+            parts:         ContextParts{Summary: Message{Content: words(30)}, Profile: Message{Content: words(3)}, History: messages(1, 2)},
+            wantHistory:   1,
+            wantTruncated: 1,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            budget := NewContextBudget(config, wordTokenizer{})
+            if tt.parts.System.Content == "" {
+                tt.parts.System = Message{Role: "system", Content: words(4)}
+            }
+            tt.parts.Input = Message{Role: "user", Content: words(2)}
+
+            msgs, usage := budget.Allocate(tt.parts)
+            if usage.DroppedMessages != tt.wantDropped {
+                t.Errorf("dropped = %d, want %d", usage.DroppedMessages, tt.wantDropped)
+            }
+            if usage.Truncated != tt.wantTruncated {
+                t.Errorf("truncated = %d, want %d", usage.Truncated, tt.wantTruncated)
+            }
+            if got := usage.History / budget.MessageTokens(Message{Content: words(2)}); got != tt.wantHistory {
+                t.Errorf("history kept = %d, want %d", got, tt.wantHistory)
+            }
+            if usage.Total > config.MaxTokens-config.ReplyTokens {
+                t.Errorf("total %d over the prompt budget", usage.Total)
+            }
+            if usage.System > config.SystemTokens || usage.Summary > config.SummaryTokens || usage.Profile > config.ProfileTokens || usage.Memories > config.MemoryTokens {
+                t.Errorf("part over its cap: %+v", usage)
+            }
+
+            // System prompt first, input last
+            if msgs[0].Role != "system" || msgs[len(msgs)-1].Content != tt.parts.Input.Content {
+                t.Errorf("prompt out of order: %+v", msgs)
+            }
+            optional := 0
+            for _, part := range []Message{tt.parts.Summary, tt.parts.Thread, tt.parts.Profile} {
+                if part.Content != "" {
+                    optional++
+                }
+            }
+            if want := 2 + optional + tt.wantHistory + tt.wantMemories; len(msgs) != want {
+                t.Errorf("got %d messages, want %d", len(msgs), want)
+            }
+        })
+    }
+}
+
+func TestContextBudgetTrimHistory(t *testing.T) {
+    budget := NewContextBudget(ContextBudgetConfig{HistoryTokens: 20}, wordTokenizer{})
+
+    tests := []struct {
+        name        string
+        history     []Message
+        wantKept    int
+        wantEvicted int
+    }{
+        // 6 tokens a message
+        {name: "cut between exchanges", history: messages(5, 2), wantKept: 3, wantEvicted: 2},
+        {name: "orphaned reply evicted", history: messages(6, 2)[1:], wantKept: 2, wantEvicted: 3},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            kept, evicted := budget.TrimHistory(tt.history)
+            if len(kept) != tt.wantKept || len(evicted) != tt.wantEvicted {
+                t.Fatalf("kept %d evicted %d, want %d and %d", len(kept), len(evicted), tt.wantKept, tt.wantEvicted)
+            }
+            if kept[0].Role != "user" {
+                t.Errorf("kept history starts with a %s message", kept[0].Role)
+            }
+        })
+    }
+}
+
+func TestTruncateToTokens(t *testing.T) {
+    tests := []struct {
+        text  string
+        limit int
+        want  string
+    }{
+        {"one two three", 5, "one two three"},
+        {"one two three", 2, "one two "},
+        {"one two three", 0, ""},
+        {"one two three", -3, ""},
+    }
+
+    for _, tt := range tests {
+        if got := truncateToTokens(wordTokenizer{}, tt.text, tt.limit); got != tt.want {
+            t.Errorf("truncateToTokens(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+        }
+    }
+}
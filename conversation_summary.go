@@ -0,0 +1,184 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+    "sync"
+    "time"
+)
+
+const summarySystemPrompt = `You keep the running memory of a live VTuber stream.
+Merge the new conversation turns into the existing summary. Keep viewer names,
+open questions, promises, running jokes and notable events. Drop small talk.
+Write in the third person, in plain sentences, and stay under %d words.`
+
+type SummaryConfig struct {
+    BatchMessages     int     // evicted messages to collect before summarizing
+    EpisodeImportance float64 // importance of the episode memory in MemoryBuffer
+    Timeout           time.Duration
+}
+
+// ConversationSummarizer folds turns evicted from the context window into a
+// running summary so the VTuber keeps track of earlier parts of the stream.
+type ConversationSummarizer struct {
+    backend      ChatBackend
+    memoryBuffer *MemoryBuffer
+    config       SummaryConfig
+    maxTokens    int
+    mu           sync.Mutex
+
+    summary      string
+    updatedAt    time.Time
+    pending      []Message
+    folding      bool
+    episodeID    string // long-term memory holding this stream's summary
+}
+
+func NewConversationSummarizer(backend ChatBackend, memoryBuffer *MemoryBuffer, config SummaryConfig, maxTokens int) *ConversationSummarizer {
+    if config.BatchMessages <= 0 {
+        config.BatchMessages = 6
+    }
+    if config.EpisodeImportance <= 0 {
+        config.EpisodeImportance = 0.8
+    }
+    if config.Timeout <= 0 {
+        config.Timeout = 30 * time.Second
+    }
+
+    return &ConversationSummarizer{
+        backend:      backend,
+        memoryBuffer: memoryBuffer,
+        config:       config,
+        maxTokens:    maxTokens,
+    }
+}
+
+// Fold queues evicted turns and summarizes them in the background once a
+// full batch has been collected.
+func (s *ConversationSummarizer) Fold(evicted []Message) {
+    if len(evicted) == 0 {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.pending = append(s.pending, evicted...)
+    if s.folding || len(s.pending) < s.config.BatchMessages {
+        return
+    }
+
+    batch := s.pending
+    s.pending = nil
+    s.folding = true
+    go s.fold(batch)
+}
+
+// Message returns the summary as a system message for the prompt
+func (s *ConversationSummarizer) Message() Message {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.summary == "" {
+        return Message{}
+    }
+    return Message{
+        Role:      "system",
+        Content:   "Earlier in this stream: " + s.summary,
+        Timestamp: s.updatedAt,
+    }
+}
+
+func (s *ConversationSummarizer) Summary() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.summary
+}
+
+func (s *ConversationSummarizer) fold(batch []Message) {
+    ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+    defer cancel()
+
+    s.mu.Lock()
+    previous := s.summary
+    s.mu.Unlock()
+
+    summary, err := s.summarize(ctx, previous, batch)
+    if err == nil {
+        s.storeEpisode(ctx, summary)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.folding = false
+
+    if err != nil {
+        // Keep the turns so the next batch retries them, but don't let a
+        // dead backend grow the queue without bound
+        log.Printf("Conversation summary failed: %v", err)
+        s.pending = append(batch, s.pending...)
+        if limit := s.config.BatchMessages * 4; len(s.pending) > limit {
+            s.pending = s.pending[len(s.pending)-limit:]
+        }
+        return
+    }
+
+    s.summary = summary
+    s.updatedAt = time.Now()
+}
+
+// storeEpisode keeps the summary around after the stream ends as a single
+// episode memory, rewritten as the summary grows. Only fold touches
+// episodeID and it never runs twice at once.
+func (s *ConversationSummarizer) storeEpisode(ctx context.Context, summary string) {
+    if s.episodeID != "" {
+        if _, err := s.memoryBuffer.Edit(ctx, s.episodeID, &summary, nil); err == nil {
+            return
+        }
+        // Forgotten or evicted since, start a new one
+    }
+    s.episodeID = s.memoryBuffer.AddLongTermMemory(summary, "episode", s.config.EpisodeImportance)
+}
+
+func (s *ConversationSummarizer) summarize(ctx context.Context, previous string, turns []Message) (string, error) {
+    var transcript strings.Builder
+    if previous != "" {
+        transcript.WriteString("Current summary:\n")
+        transcript.WriteString(previous)
+        transcript.WriteString("\n\n")
+    }
+    transcript.WriteString("New turns:\n")
+    for _, turn := range turns {
+        speaker := "Viewer"
+        if turn.Role == "assistant" {
+            speaker = "You"
+        } else if turn.Viewer != nil {
+            speaker = turn.Viewer.DisplayName()
+        }
+        fmt.Fprintf(&transcript, "%s: %s\n", speaker, turn.Content)
+    }
+
+    // Roughly three quarters of a word per token
+    words := s.maxTokens * 3 / 4
+
+    resp, err := s.backend.CreateChatCompletion(ctx, ChatRequest{
+        Messages: []Message{
+            {Role: "system", Content: fmt.Sprintf(summarySystemPrompt, words), Timestamp: time.Now()},
+            {Role: "user", Content: transcript.String(), Timestamp: time.Now()},
+        },
+        Temperature: 0.3,
+        MaxTokens:   s.maxTokens,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    summary := strings.TrimSpace(resp.Content)
+    if summary == "" {
+        return "", fmt.Errorf("%s backend returned an empty summary", s.backend.Name())
+    }
+    return summary, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "math"
+    "strings"
+    "unicode"
+
+    "github.com/sashabaranov/go-openai"
+)
+
+// Embedder turns text into a vector for similarity search
+type Embedder interface {
+    Name() string
+    Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+type EmbedderConfig struct {
+    Type       string // "hashing" (default) or "openai"
+    Model      string // defaults to text-embedding-3-small
+    BaseURL    string // for OpenAI-compatible servers
+    APIKey     string // defaults to the OpenAI key
+    Dimensions int    // hashing embedder only
+}
+
+func NewEmbedder(config EmbedderConfig, openAIKey string, usage *UsageTracker) (Embedder, error) {
+    switch config.Type {
+    case "", "hashing":
+        return NewHashingEmbedder(config.Dimensions), nil
+    case "openai":
+        apiKey := config.APIKey
+        if apiKey == "" {
+            apiKey = openAIKey
+        }
+        return NewOpenAIEmbedder(apiKey, config.BaseURL, config.Model, usage), nil
+    default:
+        return nil, fmt.Errorf("unknown embedder %q", config.Type)
+    }
+}
+
+// OpenAIEmbedder calls the OpenAI embeddings API or a compatible server
+type OpenAIEmbedder struct {
+    client *openai.Client
+    model  string
+    usage  *UsageTracker
+}
+
+func NewOpenAIEmbedder(apiKey string, baseURL string, model string, usage *UsageTracker) *OpenAIEmbedder {
+    if model == "" {
+        model = string(openai.SmallEmbedding3)
+    }
+    cfg := openai.DefaultConfig(apiKey)
+    if baseURL != "" {
+        cfg.BaseURL = baseURL
+    }
+    return &OpenAIEmbedder{
+        client: openai.NewClientWithConfig(cfg),
+        model:  model,
+        usage:  usage,
+    }
+}
+
+// Name includes the model since vectors from different models don't compare
+func (e *OpenAIEmbedder) Name() string {
+    return "openai:" + e.model
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+    resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+        Input: []string{text},
+        Model: openai.EmbeddingModel(e.model),
+    })
+    if err != nil {
+        return nil, err
+    }
+    if e.usage != nil {
+        e.usage.Record("embedding", e.model, resp.Usage.PromptTokens, 0)
+    }
+    if len(resp.Data) == 0 {
+        return nil, errors.New("embedding endpoint returned no data")
+    }
+
+    vector := make([]float64, len(resp.Data[0].Embedding))
+    for i, v := range resp.Data[0].Embedding {
+        vector[i] = float64(v)
+    }
+    normalizeVector(vector)
+    return vector, nil
+}
+
+// HashingEmbedder is a local, dependency-free embedder: word unigrams and
+// character trigrams hashed into a fixed number of buckets. It catches
+// near-duplicate phrasings, not paraphrases.
+type HashingEmbedder struct {
+    Dimensions int
+}
+
+func NewHashingEmbedder(dimensions int) *HashingEmbedder {
+    if dimensions <= 0 {
+        dimensions = 512
+    }
+    return &HashingEmbedder{Dimensions: dimensions}
+}
+
+func (e *HashingEmbedder) Name() string {
+    return "hashing"
+}
+
+func (e *HashingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+    vector := make([]float64, e.Dimensions)
+
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    })
+    for _, word := range words {
+        e.add(vector, "w:"+word, 1)
+
+        runes := []rune(" " + word + " ")
+        for i := 0; i+3 <= len(runes); i++ {
+            e.add(vector, "c:"+string(runes[i:i+3]), 0.5)
+        }
+    }
+
+    normalizeVector(vector)
+    return vector, nil
+}
+
+// The second hash bit picks a sign so collisions tend to cancel out
+func (e *HashingEmbedder) add(vector []float64, feature string, weight float64) {
+    h := fnv.New64a()
+    h.Write([]byte(feature))
+    sum := h.Sum64()
+
+    if sum&(1<<63) != 0 {
+        weight = -weight
+    }
+    vector[sum%uint64(len(vector))] += weight
+}
+
+func normalizeVector(vector []float64) {
+    norm := 0.0
+    for _, v := range vector {
+        norm += v * v
+    }
+    if norm == 0 {
+        return
+    }
+    norm = math.Sqrt(norm)
+    for i := range vector {
+        vector[i] /= norm
+    }
+}
+
+func cosineSimilarity(a []float64, b []float64) float64 {
+    if len(a) != len(b) || len(a) == 0 {
+        return 0
+    }
+
+    dot, normA, normB := 0.0, 0.0, 0.0
+    for i := range a {
+        dot += a[i] * b[i]
+        normA += a[i] * a[i]
+        normB += b[i] * b[i]
+    }
+    if normA == 0 || normB == 0 {
+        return 0
+    }
+    return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
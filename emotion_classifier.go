@@ -0,0 +1,374 @@
+package main
+
+import (
+    "context"
+    _ "embed"
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+//go:embed emotion_lexicon.json
+var embeddedEmotionLexicon []byte
+
+// EmotionClassifier labels a chat message with an emotion and a confidence
+// between 0 and 1.
+type EmotionClassifier interface {
+    Name() string
+    Classify(ctx context.Context, text string) (string, float64, error)
+}
+
+func NewEmotionClassifier(kind string, backend ChatBackend, model string) (EmotionClassifier, error) {
+    switch kind {
+    case "", "lexicon":
+        return NewLexiconClassifier()
+    case "llm":
+        return NewLLMClassifier(backend, model), nil
+    case "ensemble":
+        lexicon, err := NewLexiconClassifier()
+        if err != nil {
+            return nil, err
+        }
+        return NewEnsembleClassifier(
+            EnsembleMember{Classifier: lexicon, Weight: 1},
+            EnsembleMember{Classifier: NewLLMClassifier(backend, model), Weight: 1},
+        ), nil
+    default:
+        return nil, fmt.Errorf("unknown emotion classifier %q", kind)
+    }
+}
+
+type emotionLexicon struct {
+    Emotions map[string]struct {
+        Words map[string]float64 `json:"words"`
+        Emoji map[string]float64 `json:"emoji"`
+    } `json:"emotions"`
+    Negations    []string           `json:"negations"`
+    Negated      map[string]string  `json:"negated"`
+    Intensifiers map[string]float64 `json:"intensifiers"`
+    Postfix      map[string]float64 `json:"postfix_intensifiers"` // strengthen the word before, "hyped af"
+    Diminishers  map[string]float64 `json:"diminishers"`
+}
+
+type lexiconHit struct {
+    emotion string
+    weight  float64
+}
+
+// LexiconClassifier works offline from the embedded word and emoji lexicon.
+// It understands negation ("not happy"), intensifiers ("so hyped", "hyped
+// af"), stretched words ("sooo"), emoticons and crypto/stream slang.
+type LexiconClassifier struct {
+    words     map[string][]lexiconHit
+    emoji     map[string][]lexiconHit
+    negations map[string]bool
+    negated   map[string]string
+    modifiers map[string]float64
+    postfix   map[string]float64
+    labels    []string
+}
+
+func NewLexiconClassifier() (*LexiconClassifier, error) {
+    var lexicon emotionLexicon
+    if err := json.Unmarshal(embeddedEmotionLexicon, &lexicon); err != nil {
+        return nil, fmt.Errorf("invalid emotion lexicon: %w", err)
+    }
+
+    c := &LexiconClassifier{
+        words:     make(map[string][]lexiconHit),
+        emoji:     make(map[string][]lexiconHit),
+        negations: make(map[string]bool),
+        negated:   lexicon.Negated,
+        modifiers: make(map[string]float64),
+        postfix:   lexicon.Postfix,
+    }
+    for emotion, entries := range lexicon.Emotions {
+        c.labels = append(c.labels, emotion)
+        for word, weight := range entries.Words {
+            c.words[word] = append(c.words[word], lexiconHit{emotion, weight})
+        }
+        for emoji, weight := range entries.Emoji {
+            c.emoji[emoji] = append(c.emoji[emoji], lexiconHit{emotion, weight})
+        }
+    }
+    for _, emotion := range lexicon.Negated {
+        if !containsString(c.labels, emotion) {
+            c.labels = append(c.labels, emotion)
+        }
+    }
+    for _, word := range lexicon.Negations {
+        c.negations[word] = true
+    }
+    for word, factor := range lexicon.Intensifiers {
+        c.modifiers[word] = factor
+    }
+    for word, factor := range lexicon.Diminishers {
+        c.modifiers[word] = factor
+    }
+
+    return c, nil
+}
+
+func (c *LexiconClassifier) Name() string {
+    return "lexicon"
+}
+
+// Labels lists the emotions the lexicon can produce, including the
+// targets of negated words
+func (c *LexiconClassifier) Labels() []string {
+    return c.labels
+}
+
+func (c *LexiconClassifier) Classify(ctx context.Context, text string) (string, float64, error) {
+    scores := make(map[string]float64)
+
+    // Each "!" adds a little emphasis, up to three
+    emphasis := 1 + 0.1*math.Min(float64(strings.Count(text, "!")), 3)
+
+    modifier := 1.0
+    sinceNegation := -1
+
+    // The last scored word, for a postfix intensifier right after it
+    var lastHits []lexiconHit
+    var lastWeight float64
+    var lastNegated bool
+
+    for _, field := range strings.Fields(text) {
+        lower := strings.ToLower(field)
+
+        // Emoticons are whole fields, emoji can be glued to words
+        if hits, ok := c.emoji[lower]; ok {
+            c.score(scores, hits, emphasis, false)
+            continue
+        }
+        for _, r := range field {
+            if r < unicode.MaxASCII || unicode.IsLetter(r) || unicode.IsNumber(r) || r == '\uFE0F' {
+                continue
+            }
+            if hits, ok := c.emoji[string(r)]; ok {
+                c.score(scores, hits, emphasis, false)
+            }
+        }
+
+        words := strings.FieldsFunc(field, func(r rune) bool {
+            return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+        })
+        for _, word := range words {
+            lowerWord := strings.ToLower(word)
+
+            if factor, ok := c.postfix[lowerWord]; ok && lastHits != nil {
+                c.score(scores, lastHits, lastWeight*(factor-1), lastNegated)
+                lastHits = nil
+                continue
+            }
+            if c.negations[lowerWord] {
+                sinceNegation = 0
+                lastHits = nil
+                continue
+            }
+            if factor, ok := c.modifiers[lowerWord]; ok {
+                modifier *= factor
+                lastHits = nil
+                continue
+            }
+
+            hits := c.lookup(lowerWord)
+            if len(hits) == 0 {
+                lastHits = nil
+                modifier = 1.0
+                if sinceNegation >= 0 {
+                    sinceNegation++
+                }
+                continue
+            }
+
+            weight := emphasis * modifier
+            // SHOUTING counts extra
+            if len(word) >= 3 && strings.ToUpper(word) == word && strings.ToLower(word) != word {
+                weight *= 1.3
+            }
+            negated := sinceNegation >= 0 && sinceNegation < 3
+            c.score(scores, hits, weight, negated)
+            lastHits, lastWeight, lastNegated = hits, weight, negated
+
+            modifier = 1.0
+            sinceNegation = -1
+        }
+    }
+
+    best, bestScore, total := "neutral", 0.0, 0.0
+    for emotion, score := range scores {
+        total += score
+        if score > bestScore {
+            best, bestScore = emotion, score
+        }
+    }
+    if total == 0 {
+        return "neutral", 0.5, nil
+    }
+
+    // Confidence grows with how dominant and how strong the evidence is
+    confidence := (bestScore / total) * (1 - math.Exp(-2*bestScore))
+    return best, math.Max(0.3, math.Min(0.95, confidence)), nil
+}
+
+func (c *LexiconClassifier) score(scores map[string]float64, hits []lexiconHit, weight float64, negated bool) {
+    if negated {
+        // "not sad" is a weaker signal than "happy"
+        weight *= 0.6
+    }
+    for _, hit := range hits {
+        emotion := hit.emotion
+        if negated {
+            emotion = c.negated[emotion]
+            if emotion == "" {
+                emotion = "neutral"
+            }
+        }
+        scores[emotion] += hit.weight * weight
+    }
+}
+
+// lookup tries the word as typed, then with stretched letters squeezed
+// ("sooooo" -> "soo" -> "so")
+func (c *LexiconClassifier) lookup(word string) []lexiconHit {
+    if hits, ok := c.words[word]; ok {
+        return hits
+    }
+    if hits, ok := c.words[squeezeRepeats(word, 2)]; ok {
+        return hits
+    }
+    return c.words[squeezeRepeats(word, 1)]
+}
+
+func squeezeRepeats(word string, max int) string {
+    var b strings.Builder
+    var last rune
+    run := 0
+    for _, r := range word {
+        if r == last {
+            run++
+        } else {
+            last, run = r, 1
+        }
+        if run <= max {
+            b.WriteRune(r)
+        }
+    }
+    return b.String()
+}
+
+// LLMClassifier asks the chat backend for the emotion. An empty model uses
+// the backend's own.
+type LLMClassifier struct {
+    backend ChatBackend
+    model   string
+}
+
+func NewLLMClassifier(backend ChatBackend, model string) *LLMClassifier {
+    return &LLMClassifier{
+        backend: backend,
+        model:   model,
+    }
+}
+
+func (c *LLMClassifier) Name() string {
+    return "llm"
+}
+
+func (c *LLMClassifier) Classify(ctx context.Context, text string) (string, float64, error) {
+    resp, err := c.backend.CreateChatCompletion(ctx, ChatRequest{
+        Model: c.model,
+        Messages: []Message{
+            {Role: "user", Content: generateEmotionPrompt(text), Timestamp: time.Now()},
+        },
+        MaxTokens:   10,
+        Temperature: 0.3,
+    })
+    if err != nil {
+        return "", 0, err
+    }
+
+    emotion, confidence := parseEmotionResponse(resp.Content)
+    return emotion, confidence, nil
+}
+
+func generateEmotionPrompt(text string) string {
+    return fmt.Sprintf(`Classify the emotion of this live stream chat message as one of: %s.
+Answer with the emotion and a confidence from 0 to 1, for example "happy 0.8".
+
+Message: %q`, strings.Join(emotionTaxonomy().Names(), ", "), text)
+}
+
+func parseEmotionResponse(response string) (string, float64) {
+    fields := strings.Fields(strings.ToLower(strings.Trim(strings.TrimSpace(response), `".`)))
+    if len(fields) == 0 {
+        return "neutral", 0.5
+    }
+    emotion, ok := emotionTaxonomy().Resolve(strings.Trim(fields[0], ",:"))
+    if !ok {
+        return "neutral", 0.5
+    }
+
+    confidence := 0.5
+    if len(fields) > 1 {
+        if value, err := strconv.ParseFloat(fields[1], 64); err == nil && value >= 0 && value <= 1 {
+            confidence = value
+        }
+    }
+    return emotion, confidence
+}
+
+type EnsembleMember struct {
+    Classifier EmotionClassifier
+    Weight     float64
+}
+
+// EnsembleClassifier combines confidence-weighted votes; members that fail
+// are left out of the vote.
+type EnsembleClassifier struct {
+    members []EnsembleMember
+}
+
+func NewEnsembleClassifier(members ...EnsembleMember) *EnsembleClassifier {
+    return &EnsembleClassifier{members: members}
+}
+
+func (c *EnsembleClassifier) Name() string {
+    names := make([]string, 0, len(c.members))
+    for _, member := range c.members {
+        names = append(names, member.Classifier.Name())
+    }
+    return "ensemble(" + strings.Join(names, "+") + ")"
+}
+
+func (c *EnsembleClassifier) Classify(ctx context.Context, text string) (string, float64, error) {
+    votes := make(map[string]float64)
+    totalWeight := 0.0
+    var lastErr error
+
+    for _, member := range c.members {
+        emotion, confidence, err := member.Classifier.Classify(ctx, text)
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        votes[emotion] += member.Weight * confidence
+        totalWeight += member.Weight
+    }
+    if totalWeight == 0 {
+        return "", 0, fmt.Errorf("all emotion classifiers failed: %w", lastErr)
+    }
+
+    best, bestVote := "neutral", 0.0
+    for emotion, vote := range votes {
+        if vote > bestVote {
+            best, bestVote = emotion, vote
+        }
+    }
+    return best, bestVote / totalWeight, nil
+}
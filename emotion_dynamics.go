@@ -0,0 +1,144 @@
+package main
+
+import (
+    "math"
+    "time"
+)
+
+// Coordinates on 0..1 scales, 0.5 being neutral
+type VADValues struct {
+    Valence   float64 `json:"valence"`
+    Arousal   float64 `json:"arousal"`
+    Dominance float64 `json:"dominance"`
+}
+
+// getVADValues looks the label up in the emotion taxonomy; unknown labels
+// map to neutral
+func getVADValues(emotion string) VADValues {
+    return emotionTaxonomy().Get(emotion).VAD
+}
+
+// Hysteresis and MinDwell are pointers so either can be turned off with an
+// explicit 0; withDefaults fills in nil ones
+type EmotionDynamicsConfig struct {
+    Baseline   string         // persona's resting mood
+    HalfLife   time.Duration  // time for half the distance to the baseline to decay
+    Impulse    float64        // how far a fully confident analysis moves the mood
+    Hysteresis *float64       // VAD distance a new label must win by, nil for 0.08
+    MinDwell   *time.Duration // minimum time a primary emotion is held, nil for 4s
+}
+
+func (c EmotionDynamicsConfig) withDefaults() EmotionDynamicsConfig {
+    c.Baseline = emotionTaxonomy().Canonical(c.Baseline)
+    if c.HalfLife <= 0 {
+        c.HalfLife = 90 * time.Second
+    }
+    if c.Impulse <= 0 || c.Impulse > 1 {
+        c.Impulse = 0.4
+    }
+    if c.Hysteresis == nil {
+        hysteresis := 0.08
+        c.Hysteresis = &hysteresis
+    }
+    if c.MinDwell == nil {
+        dwell := 4 * time.Second
+        c.MinDwell = &dwell
+    }
+    return c
+}
+
+// evolve decays the mood toward the baseline for the time since the last
+// update and re-labels it. Callers hold e.mu.
+func (e *EmotionEngine) evolve(now time.Time) {
+    if elapsed := now.Sub(e.lastUpdate); elapsed > 0 && !e.lastUpdate.IsZero() {
+        baseline := getVADValues(e.dynamics.Baseline)
+        keep := math.Exp(-math.Ln2 * elapsed.Seconds() / e.dynamics.HalfLife.Seconds())
+
+        e.valence = baseline.Valence + (e.valence-baseline.Valence)*keep
+        e.arousal = baseline.Arousal + (e.arousal-baseline.Arousal)*keep
+        e.dominance = baseline.Dominance + (e.dominance-baseline.Dominance)*keep
+    }
+    e.lastUpdate = now
+
+    e.relabel(now)
+    e.detectEvents(now)
+
+    // Log decay once it has visibly moved the mood since the last entry
+    mood := VADValues{Valence: e.valence, Arousal: e.arousal, Dominance: e.dominance}
+    if e.timeline != nil && (e.currentEmotion != e.logged.Emotion || vadDistance(mood, e.logged.VAD) >= 0.01) {
+        e.logState(now, "decay", "", 0)
+    }
+}
+
+// applyImpulse pulls the mood toward an analyzed emotion, more so the more
+// confident the analysis. Callers hold e.mu.
+func (e *EmotionEngine) applyImpulse(emotion string, confidence float64, now time.Time) {
+    e.evolve(now)
+
+    target := getVADValues(emotion)
+    weight := e.dynamics.Impulse * clamp(confidence, 0, 1)
+    e.valence += (target.Valence - e.valence) * weight
+    e.arousal += (target.Arousal - e.arousal) * weight
+    e.dominance += (target.Dominance - e.dominance) * weight
+
+    e.relabel(now)
+    e.detectEvents(now)
+}
+
+// Absorb pulls the mood toward an outside mood, such as chat's, by weight
+// between 0 and 1
+func (e *EmotionEngine) Absorb(mood VADValues, weight float64) {
+    defer e.publishPending()
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    now := time.Now()
+    e.evolve(now)
+
+    weight = clamp(weight, 0, 1)
+    e.valence += (mood.Valence - e.valence) * weight
+    e.arousal += (mood.Arousal - e.arousal) * weight
+    e.dominance += (mood.Dominance - e.dominance) * weight
+
+    e.relabel(now)
+    e.detectEvents(now)
+    e.logState(now, "audience", "", weight)
+}
+
+// relabel picks the emotion nearest to the current mood. The current label
+// is kept until it has been held for MinDwell and another label is closer
+// by more than the hysteresis margin, so the avatar doesn't flicker.
+func (e *EmotionEngine) relabel(now time.Time) {
+    mood := VADValues{Valence: e.valence, Arousal: e.arousal, Dominance: e.dominance}
+    nearest, nearestDistance := nearestEmotion(mood)
+
+    if e.currentEmotion == "" {
+        e.currentEmotion, e.emotionSince = nearest, now
+        return
+    }
+    if nearest == e.currentEmotion || now.Sub(e.emotionSince) < *e.dynamics.MinDwell {
+        return
+    }
+    if vadDistance(mood, getVADValues(e.currentEmotion))-nearestDistance > *e.dynamics.Hysteresis {
+        e.currentEmotion, e.emotionSince = nearest, now
+    }
+}
+
+// nearestEmotion returns the taxonomy label closest to a VAD point
+func nearestEmotion(vad VADValues) (string, float64) {
+    nearest, nearestDistance := "neutral", math.Inf(1)
+    for _, label := range emotionTaxonomy().Names() {
+        if distance := vadDistance(vad, getVADValues(label)); distance < nearestDistance {
+            nearest, nearestDistance = label, distance
+        }
+    }
+    return nearest, nearestDistance
+}
+
+func vadDistance(a VADValues, b VADValues) float64 {
+    return math.Sqrt(
+        math.Pow(a.Valence-b.Valence, 2) +
+        math.Pow(a.Arousal-b.Arousal, 2) +
+        math.Pow(a.Dominance-b.Dominance, 2),
+    )
+}
@@ -2,113 +2,289 @@ package main
 
 import (
     "context"
+    "log"
     "math"
     "sync"
     "time"
-
-    "github.com/sashabaranov/go-openai"
 )
 
 type EmotionEngine struct {
-    model           string
-    client          *openai.Client
+    classifier      EmotionClassifier
+    offline         *LexiconClassifier
     currentEmotion  string
     LastConfidence  float64
     emotionHistory  []EmotionRecord
+    timeline        *EmotionTimeline
+    logged          EmotionRecord // last state written to the timeline
+    usage           *UsageTracker
     mu             sync.RWMutex
     
     // Emotional state parameters
     arousal         float64
     valence         float64
     dominance       float64
+    dynamics        EmotionDynamicsConfig
+    lastUpdate      time.Time
+    emotionSince    time.Time
+
+    // Change notifications
+    bus             *EmotionBus
+    events          EmotionEventsConfig
+    published       publishedEmotion
+    pending         []EmotionEvent
 }
 
 type EmotionRecord struct {
-    Emotion    string
-    Timestamp  time.Time
-    Confidence float64
-    Source     string
+    Emotion    string    `json:"emotion"`
+    Secondary  string    `json:"secondary,omitempty"`
+    Blend      float64   `json:"blend,omitempty"`
+    Input      string    `json:"input,omitempty"` // what the update was analyzed as, timeline only
+    Timestamp  time.Time `json:"timestamp"`
+    Confidence float64   `json:"confidence"`
+    Source     string    `json:"source"`
+    VAD        VADValues `json:"vad"` // mood after the update
 }
 
 type EmotionState struct {
     Primary    string
     Secondary  string
+    Blend      float64 // Secondary's share of the mix, 0..1
+    Compound   string  // dyad Primary and Secondary form, if any
     Intensity  float64
     Valence    float64
     Arousal    float64
     Dominance  float64
 }
 
-func NewEmotionEngine(model string) *EmotionEngine {
-    return &EmotionEngine{
-        model:          model,
+func NewEmotionEngine(dynamics EmotionDynamicsConfig, events EmotionEventsConfig) *EmotionEngine {
+    dynamics = dynamics.withDefaults()
+    baseline := getVADValues(dynamics.Baseline)
+
+    // Start the stream in the persona's resting mood
+    e := &EmotionEngine{
         emotionHistory: make([]EmotionRecord, 0, 100),
-        arousal:        0.5,
-        valence:        0.5,
-        dominance:      0.5,
+        arousal:        baseline.Arousal,
+        valence:        baseline.Valence,
+        dominance:      baseline.Dominance,
+        dynamics:       dynamics,
+        currentEmotion: dynamics.Baseline,
+        lastUpdate:     time.Now(),
+        emotionSince:   time.Now(),
+        bus:            NewEmotionBus(),
+        events:         events.withDefaults(),
+    }
+    e.published = publishedEmotion{
+        emotion:   e.currentEmotion,
+        intensity: e.calculateEmotionalIntensity(),
+        mood:      baseline,
+    }
+
+    // The offline classifier is the default and the fallback for the others
+    offline, err := NewLexiconClassifier()
+    if err != nil {
+        log.Printf("Offline emotion classifier unavailable: %v", err)
+    } else {
+        e.offline = offline
+        e.classifier = offline
     }
+
+    return e
 }
 
-func (e *EmotionEngine) AnalyzeEmotion(text string) (string, float64) {
+// Events is the bus emotion changes are published on
+func (e *EmotionEngine) Events() *EmotionBus {
+    return e.bus
+}
+
+// SetTimeline logs the VTuber's state after every change, not only the
+// last 100 analyses
+func (e *EmotionEngine) SetTimeline(timeline *EmotionTimeline) {
     e.mu.Lock()
     defer e.mu.Unlock()
 
-    // Use OpenAI to analyze emotion
-    completion, err := e.client.CreateCompletion(context.Background(), openai.CompletionRequest{
-        Model:       e.model,
-        Prompt:      generateEmotionPrompt(text),
-        MaxTokens:   50,
-        Temperature: 0.3,
-    })
-    if err != nil {
-        return "neutral", 0.5
+    e.timeline = timeline
+}
+
+// CloseTimeline stops logging and closes the timeline file, if any
+func (e *EmotionEngine) CloseTimeline() error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    if e.timeline == nil {
+        return nil
     }
+    err := e.timeline.Close()
+    e.timeline = nil
+    return err
+}
+
+// SetClassifier replaces the classifier used for chat input
+func (e *EmotionEngine) SetClassifier(classifier EmotionClassifier) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    e.classifier = classifier
+}
+
+// SetUsageTracker lets the tracker switch analysis to the offline
+// classifier when the LLM budget runs low
+func (e *EmotionEngine) SetUsageTracker(usage *UsageTracker) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    e.usage = usage
+}
+
+func (e *EmotionEngine) AnalyzeEmotion(text string) (string, float64) {
+    defer e.publishPending()
+
+    e.mu.RLock()
+    classifier := e.classifier
+    e.mu.RUnlock()
+
+    // Stay offline while the LLM budget is tight
+    if e.usage != nil && !e.usage.AllowEmotionAnalysis() && e.offline != nil {
+        classifier = e.offline
+    }
+
+    emotion, confidence := e.classify(classifier, text)
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
 
-    // Parse emotion and confidence
-    emotion, confidence := parseEmotionResponse(completion.Choices[0].Text)
-    
     // Update emotional state
-    e.updateEmotionalState(emotion, confidence)
+    e.LastConfidence = confidence
+    e.updateEmotionalState(emotion, confidence, "analysis")
     
     return emotion, confidence
 }
 
-func (e *EmotionEngine) updateEmotionalState(emotion string, confidence float64) {
+// AnalyzeResponse labels the VTuber's own reply. Replies are classified
+// offline since streamed replies are analyzed sentence by sentence.
+func (e *EmotionEngine) AnalyzeResponse(text string) string {
+    defer e.publishPending()
+
+    var classifier EmotionClassifier
+    if e.offline != nil {
+        classifier = e.offline
+    }
+    emotion, confidence := e.classify(classifier, text)
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    e.updateEmotionalState(emotion, confidence, "response")
+    return emotion
+}
+
+func (e *EmotionEngine) classify(classifier EmotionClassifier, text string) (string, float64) {
+    if classifier == nil {
+        return "neutral", 0.5
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    emotion, confidence, err := classifier.Classify(ctx, text)
+    if err == nil {
+        return emotion, confidence
+    }
+
+    log.Printf("Emotion classifier %s failed: %v", classifier.Name(), err)
+    if e.offline != nil && classifier != EmotionClassifier(e.offline) {
+        return e.classify(e.offline, text)
+    }
+    return "neutral", 0.5
+}
+
+func (e *EmotionEngine) updateEmotionalState(emotion string, confidence float64, source string) {
+    emotion = emotionTaxonomy().Canonical(emotion)
+    now := time.Now()
+
+    // Update VAD (Valence-Arousal-Dominance) values
+    e.applyImpulse(emotion, confidence, now)
+
     // Update emotion history
-    e.emotionHistory = append(e.emotionHistory, EmotionRecord{
+    record := EmotionRecord{
         Emotion:    emotion,
-        Timestamp:  time.Now(),
+        Timestamp:  now,
         Confidence: confidence,
-        Source:     "analysis",
-    })
+        Source:     source,
+        VAD:        VADValues{Valence: e.valence, Arousal: e.arousal, Dominance: e.dominance},
+    }
+    e.emotionHistory = append(e.emotionHistory, record)
 
     // Trim history if needed
     if len(e.emotionHistory) > 100 {
         e.emotionHistory = e.emotionHistory[1:]
     }
 
-    // Update VAD (Valence-Arousal-Dominance) values
-    vadValues := getVADValues(emotion)
-    e.valence = e.valence*0.7 + vadValues.Valence*0.3
-    e.arousal = e.arousal*0.7 + vadValues.Arousal*0.3
-    e.dominance = e.dominance*0.7 + vadValues.Dominance*0.3
+    e.logState(now, source, emotion, confidence)
+}
+
+// logState writes the state the update left the VTuber in to the timeline,
+// so a replay shows what viewers saw rather than what was analyzed. Callers
+// hold e.mu.
+func (e *EmotionEngine) logState(now time.Time, source string, input string, confidence float64) {
+    if e.timeline == nil {
+        return
+    }
+
+    state := e.state(now)
+    record := EmotionRecord{
+        Emotion:    state.Primary,
+        Secondary:  state.Secondary,
+        Blend:      state.Blend,
+        Input:      input,
+        Timestamp:  now,
+        Confidence: confidence,
+        Source:     source,
+        VAD:        VADValues{Valence: state.Valence, Arousal: state.Arousal, Dominance: state.Dominance},
+    }
+    e.logged = record
+    if err := e.timeline.Append(record); err != nil {
+        log.Printf("Failed to write emotion timeline: %v", err)
+    }
 }
 
 func (e *EmotionEngine) GetTemperatureModifier(emotion string) float64 {
-    // Calculate temperature modifier based on emotional state
-    arousalMod := (e.arousal - 0.5) * 0.2
-    valenceMod := (e.valence - 0.5) * 0.1
+    defer e.publishPending()
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    e.evolve(time.Now())
+
+    // Calculate temperature modifier from the current mood, pulled towards
+    // the emotion we're responding to
+    vad := getVADValues(emotion)
+    arousal := e.arousal*0.5 + vad.Arousal*0.5
+    valence := e.valence*0.5 + vad.Valence*0.5
+
+    arousalMod := (arousal - 0.5) * 0.2
+    valenceMod := (valence - 0.5) * 0.1
     
     return arousalMod + valenceMod
 }
 
+// GetCurrentEmotionalState returns the mood as it has evolved up to now
 func (e *EmotionEngine) GetCurrentEmotionalState() EmotionState {
-    e.mu.RLock()
-    defer e.mu.RUnlock()
+    defer e.publishPending()
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    now := time.Now()
+    e.evolve(now)
+    return e.state(now)
+}
 
+// Callers hold e.mu
+func (e *EmotionEngine) state(now time.Time) EmotionState {
+    secondary, blend := e.calculateSecondaryEmotion(now)
     return EmotionState{
         Primary:   e.currentEmotion,
-        Secondary: e.calculateSecondaryEmotion(),
+        Secondary: secondary,
+        Blend:     blend,
+        Compound:  emotionTaxonomy().Compound(e.currentEmotion, secondary),
         Intensity: e.calculateEmotionalIntensity(),
         Valence:   e.valence,
         Arousal:   e.arousal,
@@ -117,20 +293,70 @@ func (e *EmotionEngine) GetCurrentEmotionalState() EmotionState {
 }
 
 func (e *EmotionEngine) calculateEmotionalIntensity() float64 {
-    // Calculate intensity based on VAD values
+    return vadIntensity(VADValues{Valence: e.valence, Arousal: e.arousal, Dominance: e.dominance})
+}
+
+// vadIntensity is the distance from a neutral mood, scaled to 0..1
+func vadIntensity(vad VADValues) float64 {
     return math.Sqrt(
-        math.Pow(e.valence-0.5, 2) +
-        math.Pow(e.arousal-0.5, 2) +
-        math.Pow(e.dominance-0.5, 2),
+        math.Pow(vad.Valence-0.5, 2) +
+        math.Pow(vad.Arousal-0.5, 2) +
+        math.Pow(vad.Dominance-0.5, 2),
     ) / math.Sqrt(0.75)
 }
 
-func (e *EmotionEngine) calculateSecondaryEmotion() string {
-    // Implement complex emotion blending logic
-    // This is a simplified version
-    if len(e.emotionHistory) < 2 {
-        return "neutral"
+// calculateSecondaryEmotion scores every emotion by recent history, weighted
+// by recency and confidence, and by how close the current mood is to it. It
+// returns the strongest emotion besides the primary and its share of the
+// two. The share can pass 0.5 while the primary is held by hysteresis, so
+// the mix moves smoothly when the primary finally switches.
+func (e *EmotionEngine) calculateSecondaryEmotion(now time.Time) (string, float64) {
+    mood := VADValues{Valence: e.valence, Arousal: e.arousal, Dominance: e.dominance}
+    names := emotionTaxonomy().Names()
+
+    history := make(map[string]float64)
+    historyTotal := 0.0
+    for _, record := range e.emotionHistory {
+        age := now.Sub(record.Timestamp).Seconds()
+        weight := record.Confidence * math.Exp(-math.Ln2*age/e.dynamics.HalfLife.Seconds())
+        history[record.Emotion] += weight
+        historyTotal += weight
     }
-    
-    return e.emotionHistory[len(e.emotionHistory)-2].Emotion
+
+    scores := make(map[string]float64, len(names))
+    proximityTotal := 0.0
+    for _, label := range names {
+        scores[label] = math.Exp(-vadDistance(mood, getVADValues(label)) / 0.15)
+        proximityTotal += scores[label]
+    }
+    for label := range scores {
+        scores[label] /= proximityTotal
+        if historyTotal > 0 {
+            scores[label] = 0.5*scores[label] + 0.5*history[label]/historyTotal
+        }
+    }
+
+    secondary, secondaryScore := "", 0.0
+    for _, label := range names {
+        if label != e.currentEmotion && scores[label] > secondaryScore {
+            secondary, secondaryScore = label, scores[label]
+        }
+    }
+    total := scores[e.currentEmotion] + secondaryScore
+    if secondary == "" || total == 0 {
+        return "neutral", 0
+    }
+    return secondary, secondaryScore / total
+}
+
+// MixFor returns the emotion to blend with label and its share, taken from
+// this state when label is its primary or secondary
+func (s EmotionState) MixFor(label string) (string, float64) {
+    switch label {
+    case s.Primary:
+        return s.Secondary, s.Blend
+    case s.Secondary:
+        return s.Primary, 1 - s.Blend
+    }
+    return "", 0
 } 
\ No newline at end of file
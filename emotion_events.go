@@ -0,0 +1,295 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type EmotionEventType string
+
+const (
+    EmotionChanged   EmotionEventType = "emotion_changed"   // primary emotion switched
+    IntensityCrossed EmotionEventType = "intensity_crossed" // intensity crossed the threshold
+    MoodShifted      EmotionEventType = "mood_shifted"      // VAD moved by more than MoodShift
+)
+
+type EmotionEvent struct {
+    Type      EmotionEventType `json:"type"`
+    Timestamp time.Time        `json:"timestamp"`
+    State     EmotionState     `json:"state"`
+    Previous  string           `json:"previous,omitempty"` // primary before an emotion change
+    Rising    bool             `json:"rising,omitempty"`   // intensity crossed upwards
+    Shift     float64          `json:"shift,omitempty"`    // VAD distance of a mood shift
+}
+
+type EmotionEventsConfig struct {
+    IntensityThreshold float64 // defaults to the stream's EmotionThreshold
+    MoodShift          float64 // VAD distance that counts as a mood shift
+}
+
+func (c EmotionEventsConfig) withDefaults() EmotionEventsConfig {
+    if c.IntensityThreshold <= 0 {
+        c.IntensityThreshold = 0.5
+    }
+    if c.MoodShift <= 0 {
+        c.MoodShift = 0.15
+    }
+    return c
+}
+
+// SlowConsumerPolicy decides what happens when a subscriber's buffer is full
+type SlowConsumerPolicy string
+
+const (
+    PolicyDropOldest SlowConsumerPolicy = "drop_oldest" // make room by discarding the oldest event
+    PolicyDropNewest SlowConsumerPolicy = "drop_newest" // discard the event being published
+    PolicyBlock      SlowConsumerPolicy = "block"       // wait up to BlockTimeout, then drop
+    PolicyDisconnect SlowConsumerPolicy = "disconnect"  // unsubscribe the consumer
+)
+
+type SubscribeOptions struct {
+    Types        []EmotionEventType // empty for every type
+    Buffer       int
+    Policy       SlowConsumerPolicy
+    BlockTimeout time.Duration
+}
+
+type EmotionSubscription struct {
+    C <-chan EmotionEvent
+
+    id      int
+    bus     *EmotionBus
+    ch      chan EmotionEvent
+    options SubscribeOptions
+    sendMu  sync.Mutex
+    dropped int64
+}
+
+// Unsubscribe stops delivery and closes C. It is safe to call more than once.
+func (s *EmotionSubscription) Unsubscribe() {
+    s.bus.unsubscribe(s.id)
+}
+
+// Dropped counts events the subscriber missed for being slow
+func (s *EmotionSubscription) Dropped() int64 {
+    return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *EmotionSubscription) wants(eventType EmotionEventType) bool {
+    if len(s.options.Types) == 0 {
+        return true
+    }
+    for _, t := range s.options.Types {
+        if t == eventType {
+            return true
+        }
+    }
+    return false
+}
+
+// deliver reports false when the subscriber should be disconnected
+func (s *EmotionSubscription) deliver(event EmotionEvent) bool {
+    s.sendMu.Lock()
+    defer s.sendMu.Unlock()
+
+    select {
+    case s.ch <- event:
+        return true
+    default:
+    }
+
+    switch s.options.Policy {
+    case PolicyDropNewest:
+    case PolicyBlock:
+        timer := time.NewTimer(s.options.BlockTimeout)
+        defer timer.Stop()
+        select {
+        case s.ch <- event:
+            return true
+        case <-timer.C:
+        }
+    case PolicyDisconnect:
+        atomic.AddInt64(&s.dropped, 1)
+        return false
+    default:
+        select {
+        case <-s.ch:
+            atomic.AddInt64(&s.dropped, 1)
+        default:
+        }
+        select {
+        case s.ch <- event:
+            return true
+        default:
+        }
+    }
+    atomic.AddInt64(&s.dropped, 1)
+    return true
+}
+
+// EmotionBus is an in-process pub/sub for emotion events so components
+// don't have to poll the emotion engine
+type EmotionBus struct {
+    mu     sync.RWMutex
+    subs   map[int]*EmotionSubscription
+    nextID int
+}
+
+func NewEmotionBus() *EmotionBus {
+    return &EmotionBus{subs: make(map[int]*EmotionSubscription)}
+}
+
+func (b *EmotionBus) Subscribe(options SubscribeOptions) *EmotionSubscription {
+    if options.Buffer <= 0 {
+        options.Buffer = 16
+    }
+    if options.Policy == "" {
+        options.Policy = PolicyDropOldest
+    }
+    if options.BlockTimeout <= 0 {
+        options.BlockTimeout = 100 * time.Millisecond
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.nextID++
+    ch := make(chan EmotionEvent, options.Buffer)
+    sub := &EmotionSubscription{C: ch, id: b.nextID, bus: b, ch: ch, options: options}
+    b.subs[sub.id] = sub
+    return sub
+}
+
+// Publish delivers event to every interested subscriber. Only PolicyBlock
+// can make it wait.
+func (b *EmotionBus) Publish(event EmotionEvent) {
+    var slow []int
+
+    b.mu.RLock()
+    for id, sub := range b.subs {
+        if sub.wants(event.Type) && !sub.deliver(event) {
+            slow = append(slow, id)
+        }
+    }
+    b.mu.RUnlock()
+
+    for _, id := range slow {
+        log.Printf("Disconnecting slow emotion subscriber %d", id)
+        b.unsubscribe(id)
+    }
+}
+
+func (b *EmotionBus) unsubscribe(id int) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if sub, ok := b.subs[id]; ok {
+        delete(b.subs, id)
+        close(sub.ch)
+    }
+}
+
+type publishedEmotion struct {
+    emotion   string
+    intensity float64
+    mood      VADValues
+}
+
+// detectEvents queues events for what changed since the last ones were
+// queued. Callers hold e.mu.
+func (e *EmotionEngine) detectEvents(now time.Time) {
+    mood := VADValues{Valence: e.valence, Arousal: e.arousal, Dominance: e.dominance}
+    intensity := e.calculateEmotionalIntensity()
+
+    var events []EmotionEvent
+    if e.currentEmotion != e.published.emotion {
+        events = append(events, EmotionEvent{Type: EmotionChanged, Previous: e.published.emotion})
+        e.published.emotion = e.currentEmotion
+    }
+
+    threshold := e.events.IntensityThreshold
+    if rising := intensity >= threshold; rising != (e.published.intensity >= threshold) {
+        events = append(events, EmotionEvent{Type: IntensityCrossed, Rising: rising})
+    }
+    e.published.intensity = intensity
+
+    if shift := vadDistance(e.published.mood, mood); shift >= e.events.MoodShift {
+        events = append(events, EmotionEvent{Type: MoodShifted, Shift: shift})
+        e.published.mood = mood
+    }
+
+    if len(events) == 0 {
+        return
+    }
+    state := e.state(now)
+    for i := range events {
+        events[i].Timestamp = now
+        events[i].State = state
+    }
+    e.pending = append(e.pending, events...)
+}
+
+// publishPending publishes the queued events. It is deferred before e.mu is
+// taken so it runs after the unlock and a blocking subscriber never holds
+// up the engine.
+func (e *EmotionEngine) publishPending() {
+    e.mu.Lock()
+    events := e.pending
+    e.pending = nil
+    e.mu.Unlock()
+
+    for _, event := range events {
+        e.bus.Publish(event)
+    }
+}
+
+// PostEmotionEvents forwards events to a webhook as JSON until ctx is done
+// or the subscription ends
+func PostEmotionEvents(ctx context.Context, sub *EmotionSubscription, url string) {
+    defer sub.Unsubscribe()
+
+    client := &http.Client{Timeout: 5 * time.Second}
+    for {
+        select {
+        case event, ok := <-sub.C:
+            if !ok {
+                return
+            }
+            if err := postEmotionEvent(ctx, client, url, event); err != nil {
+                log.Printf("Emotion webhook failed: %v", err)
+            }
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+func postEmotionEvent(ctx context.Context, client *http.Client, url string, event EmotionEvent) error {
+    body, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
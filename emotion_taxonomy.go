@@ -0,0 +1,232 @@
+package main
+
+import (
+    _ "embed"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+)
+
+//go:embed emotions.json
+var embeddedEmotionTaxonomy []byte
+
+type EmotionDefinition struct {
+    Name       string             `json:"-"`
+    VAD        VADValues          `json:"vad"`
+    Voice      VoiceModifier      `json:"voice"`
+    Expression map[string]float64 `json:"expression"`
+    Aliases    []string           `json:"aliases"`
+}
+
+// EmotionTaxonomy is the one emotion label set shared by the emotion
+// engine, voice, avatar and sampling policy.
+type EmotionTaxonomy struct {
+    emotions map[string]*EmotionDefinition
+    aliases  map[string]string
+    names    []string
+    dyads    map[[2]string]string // compound emotions, keyed by sorted pair
+}
+
+var (
+    activeTaxonomy   *EmotionTaxonomy
+    activeTaxonomyMu sync.RWMutex
+)
+
+func init() {
+    taxonomy, err := ParseEmotionTaxonomy(embeddedEmotionTaxonomy)
+    if err != nil {
+        panic(fmt.Sprintf("embedded emotions.json: %v", err))
+    }
+    activeTaxonomy = taxonomy
+}
+
+func LoadEmotionTaxonomy(path string) (*EmotionTaxonomy, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read emotion taxonomy: %w", err)
+    }
+
+    taxonomy, err := ParseEmotionTaxonomy(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return taxonomy, nil
+}
+
+func ParseEmotionTaxonomy(data []byte) (*EmotionTaxonomy, error) {
+    var file struct {
+        Emotions map[string]*EmotionDefinition `json:"emotions"`
+        Dyads    map[string][2]string          `json:"dyads"`
+    }
+    decoder := json.NewDecoder(strings.NewReader(string(data)))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&file); err != nil {
+        return nil, fmt.Errorf("invalid emotion taxonomy: %w", err)
+    }
+    if _, ok := file.Emotions["neutral"]; !ok {
+        return nil, errors.New("emotion taxonomy must define neutral")
+    }
+
+    t := &EmotionTaxonomy{
+        emotions: make(map[string]*EmotionDefinition, len(file.Emotions)),
+        aliases:  make(map[string]string),
+        dyads:    make(map[[2]string]string),
+    }
+    for name, def := range file.Emotions {
+        name = strings.ToLower(name)
+        def.Name = name
+        if !inUnitRange(def.VAD.Valence) || !inUnitRange(def.VAD.Arousal) || !inUnitRange(def.VAD.Dominance) {
+            return nil, fmt.Errorf("emotion %q: VAD values must be between 0 and 1", name)
+        }
+        t.emotions[name] = def
+        t.names = append(t.names, name)
+    }
+    sort.Strings(t.names)
+
+    for _, name := range t.names {
+        for _, alias := range t.emotions[name].Aliases {
+            alias = strings.ToLower(alias)
+            if _, ok := t.emotions[alias]; ok {
+                return nil, fmt.Errorf("alias %q of %q is itself an emotion", alias, name)
+            }
+            if other, ok := t.aliases[alias]; ok && other != name {
+                return nil, fmt.Errorf("alias %q is used by both %q and %q", alias, other, name)
+            }
+            t.aliases[alias] = name
+        }
+    }
+
+    for compound, pair := range file.Dyads {
+        if err := t.Validate(fmt.Sprintf("dyad %q", compound), pair[0], pair[1]); err != nil {
+            return nil, err
+        }
+        key := dyadKey(t.Canonical(pair[0]), t.Canonical(pair[1]))
+        if key[0] == key[1] {
+            return nil, fmt.Errorf("dyad %q needs two different emotions", compound)
+        }
+        if other, ok := t.dyads[key]; ok {
+            return nil, fmt.Errorf("dyads %q and %q combine the same emotions", other, compound)
+        }
+        t.dyads[key] = compound
+    }
+
+    return t, nil
+}
+
+func inUnitRange(value float64) bool {
+    return value >= 0 && value <= 1
+}
+
+// SetEmotionTaxonomy replaces the built-in taxonomy; call it at startup
+// before creating components.
+func SetEmotionTaxonomy(taxonomy *EmotionTaxonomy) {
+    activeTaxonomyMu.Lock()
+    defer activeTaxonomyMu.Unlock()
+
+    activeTaxonomy = taxonomy
+}
+
+func emotionTaxonomy() *EmotionTaxonomy {
+    activeTaxonomyMu.RLock()
+    defer activeTaxonomyMu.RUnlock()
+
+    return activeTaxonomy
+}
+
+// Resolve maps a label or alias to its emotion name
+func (t *EmotionTaxonomy) Resolve(label string) (string, bool) {
+    label = strings.ToLower(strings.TrimSpace(label))
+    if _, ok := t.emotions[label]; ok {
+        return label, true
+    }
+    name, ok := t.aliases[label]
+    return name, ok
+}
+
+// Canonical is Resolve with unknown labels mapped to neutral
+func (t *EmotionTaxonomy) Canonical(label string) string {
+    if name, ok := t.Resolve(label); ok {
+        return name
+    }
+    return "neutral"
+}
+
+func (t *EmotionTaxonomy) Get(label string) *EmotionDefinition {
+    return t.emotions[t.Canonical(label)]
+}
+
+func (t *EmotionTaxonomy) Names() []string {
+    return t.names
+}
+
+// Compound names the dyad two emotions form, such as happy + surprised
+// giving delight, or "" if they form none
+func (t *EmotionTaxonomy) Compound(a string, b string) string {
+    return t.dyads[dyadKey(t.Canonical(a), t.Canonical(b))]
+}
+
+func dyadKey(a string, b string) [2]string {
+    if b < a {
+        a, b = b, a
+    }
+    return [2]string{a, b}
+}
+
+// Validate fails when a component refers to a label the taxonomy doesn't know
+func (t *EmotionTaxonomy) Validate(component string, labels ...string) error {
+    var unknown []string
+    for _, label := range labels {
+        if _, ok := t.Resolve(label); !ok {
+            unknown = append(unknown, label)
+        }
+    }
+    if len(unknown) > 0 {
+        return fmt.Errorf("%s references unknown emotions: %s", component, strings.Join(unknown, ", "))
+    }
+    return nil
+}
+
+// InitEmotionTaxonomy loads the configured taxonomy and checks that every
+// component only refers to emotions it defines
+func InitEmotionTaxonomy(config AIConfig) error {
+    if config.EmotionTaxonomy != "" {
+        taxonomy, err := LoadEmotionTaxonomy(config.EmotionTaxonomy)
+        if err != nil {
+            return err
+        }
+        SetEmotionTaxonomy(taxonomy)
+    }
+    return ValidateEmotionReferences(config)
+}
+
+func ValidateEmotionReferences(config AIConfig) error {
+    taxonomy := emotionTaxonomy()
+
+    modifiers := config.Sampling.EmotionModifiers
+    if modifiers == nil {
+        modifiers = defaultEmotionModifiers
+    }
+    samplingLabels := make([]string, 0, len(modifiers))
+    for label := range modifiers {
+        samplingLabels = append(samplingLabels, label)
+    }
+    if err := taxonomy.Validate("sampling modifiers", samplingLabels...); err != nil {
+        return err
+    }
+
+    if baseline := config.EmotionDynamics.Baseline; baseline != "" {
+        if err := taxonomy.Validate("emotion dynamics baseline", baseline); err != nil {
+            return err
+        }
+    }
+
+    lexicon, err := NewLexiconClassifier()
+    if err != nil {
+        return err
+    }
+    return taxonomy.Validate("emotion lexicon", lexicon.Labels()...)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+// EmotionTimeline appends the VTuber's emotional state after every change
+// during a stream to a JSON lines file, unlike emotionHistory which only
+// keeps the last 100 analyses
+type EmotionTimeline struct {
+    file *os.File
+    mu   sync.Mutex
+}
+
+func OpenEmotionTimeline(path string) (*EmotionTimeline, error) {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open emotion timeline: %w", err)
+    }
+    return &EmotionTimeline{file: file}, nil
+}
+
+func (t *EmotionTimeline) Append(record EmotionRecord) error {
+    line, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    _, err = t.file.Write(append(line, '\n'))
+    return err
+}
+
+func (t *EmotionTimeline) Close() error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    return t.file.Close()
+}
+
+// ReadEmotionTimeline loads a timeline in order. A torn last line, left by a
+// crash mid-write, is skipped.
+func ReadEmotionTimeline(path string) ([]EmotionRecord, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open emotion timeline: %w", err)
+    }
+    defer file.Close()
+
+    var records []EmotionRecord
+    var bad error
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line++ {
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+        if bad != nil {
+            return nil, bad
+        }
+
+        var record EmotionRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            bad = fmt.Errorf("emotion timeline line %d: %w", line, err)
+            continue
+        }
+        records = append(records, record)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read emotion timeline: %w", err)
+    }
+    if bad != nil {
+        log.Printf("Skipping torn end of emotion timeline: %v", bad)
+    }
+
+    return records, nil
+}
+
+// EmotionReplay plays a recorded timeline back against the avatar and voice
+// so expressions can be tuned offline on real stream data
+type EmotionReplay struct {
+    Speed  float64 // 1 is real time, 10 ten times faster
+    Avatar *AvatarRenderer
+    Voice  *VoiceSynthesizer
+
+    // Called for every record with the voice modifier it produced
+    OnRecord func(record EmotionRecord, modifier VoiceModifier)
+}
+
+func (r *EmotionReplay) Run(ctx context.Context, records []EmotionRecord) error {
+    speed := r.Speed
+    if speed <= 0 {
+        speed = 1
+    }
+
+    for i, record := range records {
+        if i > 0 {
+            wait := time.Duration(float64(record.Timestamp.Sub(records[i-1].Timestamp)) / speed)
+            if wait > 0 {
+                timer := time.NewTimer(wait)
+                select {
+                case <-timer.C:
+                case <-ctx.Done():
+                    timer.Stop()
+                    return ctx.Err()
+                }
+            }
+        }
+
+        if r.Avatar != nil {
+            r.Avatar.UpdateBlend(record.Emotion, record.Secondary, record.Blend, vadIntensity(record.VAD))
+        }
+
+        var modifier VoiceModifier
+        if r.Voice != nil {
+            modifier = r.Voice.ApplyBlend(record.Emotion, record.Secondary, record.Blend)
+        } else {
+            modifier = emotionTaxonomy().Get(record.Emotion).Voice
+            if record.Secondary != "" && record.Blend > 0 {
+                modifier = blendVoiceModifiers(modifier, emotionTaxonomy().Get(record.Secondary).Voice, record.Blend)
+            }
+        }
+
+        if r.OnRecord != nil {
+            r.OnRecord(record, modifier)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+)
+
+var languageNames = map[string]string{
+    "en": "English",
+    "ja": "Japanese",
+    "es": "Spanish",
+    "pt": "Portuguese",
+}
+
+// Common short words that rarely appear in the other languages' chat
+var languageStopwords = map[string][]string{
+    "en": {"the", "and", "is", "are", "you", "your", "to", "of", "what", "it", "this", "that", "how", "for", "with", "i", "my", "do", "can", "hi", "hello", "thanks"},
+    "es": {"el", "la", "los", "las", "que", "qué", "y", "es", "eres", "por", "para", "una", "un", "con", "como", "cómo", "pero", "muy", "tú", "hola", "gracias", "estás", "yo"},
+    "pt": {"o", "os", "as", "que", "e", "é", "você", "vc", "para", "pra", "uma", "um", "com", "como", "não", "muito", "obrigado", "olá", "oi", "tudo", "bem", "eu", "está"},
+}
+
+// Letters only one of the Latin-script languages uses
+var languageHints = map[string]string{
+    "es": "ñ¿¡",
+    "pt": "ãõç",
+}
+
+type LanguageConfig struct {
+    Policy    string   // "mirror" replies in the viewer's language, "main" sticks to Main
+    Main      string   // ISO 639-1 code, defaults to "en"
+    Supported []string // languages we will mirror
+}
+
+// ReplyLanguage is the language to speak in and, under the "main" policy,
+// the viewer's language for a translated subtitle.
+type ReplyLanguage struct {
+    Spoken   string
+    Subtitle string
+}
+
+// DetectLanguage returns the language of a chat message, or "" when the
+// message is too short or mixed to tell.
+func DetectLanguage(text string) string {
+    letters, japanese := 0, 0
+    for _, r := range text {
+        if !unicode.IsLetter(r) {
+            continue
+        }
+        letters++
+        if unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) {
+            japanese++
+        }
+    }
+    if letters == 0 {
+        return ""
+    }
+    if japanese*3 >= letters {
+        return "ja"
+    }
+
+    lower := strings.ToLower(text)
+    words := strings.FieldsFunc(lower, func(r rune) bool {
+        return !unicode.IsLetter(r)
+    })
+
+    scores := make(map[string]int)
+    for lang, stopwords := range languageStopwords {
+        for _, word := range words {
+            if containsString(stopwords, word) {
+                scores[lang]++
+            }
+        }
+    }
+    for lang, hints := range languageHints {
+        if strings.ContainsAny(lower, hints) {
+            scores[lang] += 2
+        }
+    }
+
+    best, bestScore, tied := "", 0, false
+    for lang, score := range scores {
+        switch {
+        case score > bestScore:
+            best, bestScore, tied = lang, score, false
+        case score == bestScore:
+            tied = true
+        }
+    }
+    if bestScore == 0 || tied {
+        return ""
+    }
+    return best
+}
+
+func (c LanguageConfig) mainLanguage() string {
+    if c.Main == "" {
+        return "en"
+    }
+    return c.Main
+}
+
+func (c LanguageConfig) supports(lang string) bool {
+    if len(c.Supported) == 0 {
+        _, ok := languageNames[lang]
+        return ok
+    }
+    return containsString(c.Supported, lang)
+}
+
+// Choose picks the reply language for a message in detected
+func (c LanguageConfig) Choose(detected string) ReplyLanguage {
+    main := c.mainLanguage()
+    if detected == "" || detected == main || !c.supports(detected) {
+        return ReplyLanguage{Spoken: main}
+    }
+
+    if c.Policy == "main" {
+        return ReplyLanguage{Spoken: main, Subtitle: detected}
+    }
+    return ReplyLanguage{Spoken: detected}
+}
+
+func languageInstruction(lang string) string {
+    name, ok := languageNames[lang]
+    if !ok {
+        name = lang
+    }
+    return "Reply in " + name + "."
+}
@@ -3,19 +3,33 @@ package main
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
+    "log"
+    "strings"
     "time"
     "sync"
-
-    "github.com/sashabaranov/go-openai"
 )
 
 type LLMProcessor struct {
-    client         *openai.Client
+    backend        ChatBackend
+    subtitleBackend ChatBackend
+    rephraseBackend ChatBackend
     config         AIConfig
     memoryBuffer   *MemoryBuffer
     emotionEngine  *EmotionEngine
     personality    *PersonalityVector
+    budget         *ContextBudget
+    summarizer     *ConversationSummarizer
+    tools          *ToolRegistry
+    threads        *ViewerThreads
+    profiles       *ViewerProfiles
+    moderation     *ModerationPipeline
+    usage          *UsageTracker
+    sampling       *SamplingPolicy
+    cache          *ResponseCache
+    audience       *AudienceMood
     mu            sync.Mutex
     
     // Conversation state
@@ -23,6 +37,7 @@ type LLMProcessor struct {
     lastResponse   time.Time
     emotionState   EmotionState
     interactionCount int
+    brainLagCount    int
 }
 
 type Message struct {
@@ -31,114 +46,745 @@ type Message struct {
     Timestamp time.Time `json:"timestamp"`
     Emotion   string    `json:"emotion"`
     Confidence float64  `json:"confidence"`
+    Viewer     *ViewerIdentity `json:"viewer,omitempty"`
+    Language   string     `json:"language,omitempty"`
+    ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+    ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+func NewLLMProcessor(config AIConfig, openAIKey string) (*LLMProcessor, error) {
+    primary, err := NewChatBackend(config.Backend, openAIKey)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize LLM backend: %w", err)
+    }
+
+    fallback, err := NewFallbackBackend(config.Resilience.Fallback, primary, openAIKey)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize fallback LLM backend: %w", err)
+    }
+
+    backend := NewResilientBackend(primary, fallback, config.Resilience)
+    l, err := NewLLMProcessorWithBackend(config, backend)
+    if err != nil {
+        return nil, err
+    }
+
+    classifier, err := NewEmotionClassifier(config.EmotionClassifier, NewMeteredBackend(backend, l.usage, "emotion"), config.EmotionModel)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize emotion classifier: %w", err)
+    }
+    l.emotionEngine.SetClassifier(classifier)
+
+    embedder, err := NewEmbedder(config.Memory.Embedder, openAIKey, l.usage)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize memory embedder: %w", err)
+    }
+    l.memoryBuffer.SetEmbedder(embedder)
+
+    if l.cache != nil {
+        embedder, err := NewEmbedder(config.ResponseCache.Embedder, openAIKey, l.usage)
+        if err != nil {
+            return nil, fmt.Errorf("failed to initialize response cache embedder: %w", err)
+        }
+        l.cache.SetEmbedder(embedder)
+    }
+
+    return l, nil
 }
 
-func NewLLMProcessor(config AIConfig, openAIKey string) *LLMProcessor {
-    return &LLMProcessor{
-        client: openai.NewClient(openAIKey),
+func NewLLMProcessorWithBackend(config AIConfig, backend ChatBackend) (*LLMProcessor, error) {
+    moderation, err := NewModerationPipeline(config.Moderation)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize moderation: %w", err)
+    }
+
+    // Long-term memories survive restarts when Memory.Path is set
+    memoryConfig := config.Memory
+    if memoryConfig.MaxShortTerm == 0 {
+        memoryConfig.MaxShortTerm = config.MemoryBufferSize
+    }
+    memoryBuffer, err := NewMemoryBuffer(memoryConfig)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize memory: %w", err)
+    }
+
+    profiles, err := NewViewerProfiles(config.ViewerProfiles)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize viewer profiles: %w", err)
+    }
+
+    // Every call is metered and degraded once the spend budget runs low
+    usage := NewUsageTracker(config.Usage)
+
+    l := &LLMProcessor{
+        backend: NewMeteredBackend(backend, usage, "reply"),
+        subtitleBackend: NewMeteredBackend(backend, usage, "subtitle"),
+        rephraseBackend: NewMeteredBackend(backend, usage, "rephrase"),
         config: config,
-        memoryBuffer: NewMemoryBuffer(config.MemoryBufferSize),
-        emotionEngine: NewEmotionEngine(config.EmotionModel),
+        memoryBuffer: memoryBuffer,
+        emotionEngine: NewEmotionEngine(config.EmotionDynamics, config.EmotionEvents),
         personality: NewPersonalityVector(config.PersonalityVector),
+        budget: NewContextBudget(config.ContextBudget, ApproxTokenizer{}),
+        tools: NewToolRegistry(),
+        threads: NewViewerThreads(config.ViewerThreadSize, 0),
+        profiles: profiles,
+        moderation: moderation,
+        usage: usage,
+        sampling: NewSamplingPolicy(config.Sampling, config.TemperatureBase),
         contextWindow: make([]Message, 0, config.ContextWindowSize),
     }
+    l.summarizer = NewConversationSummarizer(NewMeteredBackend(backend, usage, "summary"), l.memoryBuffer, config.Summary, l.budget.SummaryTokens())
+    l.emotionEngine.SetUsageTracker(usage)
+    if config.ResponseCache.Enabled {
+        l.cache = NewResponseCache(config.ResponseCache, nil)
+    }
+    if config.EmotionTimeline != "" {
+        timeline, err := OpenEmotionTimeline(config.EmotionTimeline)
+        if err != nil {
+            return nil, err
+        }
+        l.emotionEngine.SetTimeline(timeline)
+    }
+    if config.Audience.Enabled {
+        l.audience, err = NewAudienceMood(config.Audience, l.emotionEngine, moderation)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return l, nil
 }
 
-func (l *LLMProcessor) ProcessInput(ctx context.Context, input string) (*Response, error) {
+// ProcessInput answers one chat message. viewer may be the zero value for
+// input that has no author, such as stream events.
+func (l *LLMProcessor) ProcessInput(ctx context.Context, viewer ViewerIdentity, input string) (*Response, error) {
     l.mu.Lock()
     defer l.mu.Unlock()
 
-    // Analyze input emotion
-    emotion, confidence := l.emotionEngine.AnalyzeEmotion(input)
-    
-    // Build context with personality injection
-    messages := l.buildContextMessages()
-    messages = append(messages, Message{
-        Role:      "user",
-        Content:   input,
+    l.usage.BeginInteraction(l.interactionCount + 1)
+    turn := l.prepareTurn(viewer, input, l.config.StructuredOutput.Enabled)
+
+    // Repeated questions skip the backend
+    if text, ok := l.cachedReply(ctx, turn); ok {
+        response := l.finishResponse(turn, text, "")
+        l.addSubtitle(ctx, turn, response)
+        return response, nil
+    }
+
+    // Let the model call tools until it produces a final answer
+    for round := 0; ; round++ {
+        if round >= l.maxToolRounds() {
+            turn.request.Tools = nil
+        }
+
+        resp, err := l.backend.CreateChatCompletion(ctx, turn.request)
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil, fmt.Errorf("LLM processing error: %w", err)
+            }
+            return l.brainLagResponse(err), nil
+        }
+
+        if len(resp.ToolCalls) == 0 || turn.request.Tools == nil {
+            text := l.decodeReply(turn, resp.Content)
+
+            // Nothing reaches TTS without passing moderation
+            text, event := l.moderation.Moderate(ctx, text, "reply", l.regenerateFunc(turn))
+            if event != nil {
+                turn.moderation = append(turn.moderation, *event)
+            }
+            response := l.finishResponse(turn, text, resp.Model)
+            l.cacheReply(ctx, turn, response)
+            l.addSubtitle(ctx, turn, response)
+            return response, nil
+        }
+        l.runToolCalls(ctx, turn, resp.Content, resp.ToolCalls)
+    }
+}
+
+// regenerateFunc asks the backend for a replacement reply after moderation
+// rejected the first one.
+func (l *LLMProcessor) regenerateFunc(turn *chatTurn) func(context.Context) (string, error) {
+    return func(ctx context.Context) (string, error) {
+        req := turn.request
+        req.Tools = nil
+        req.Messages = append(append([]Message(nil), req.Messages...), Message{
+            Role:      "system",
+            Content:   "Your previous reply was not suitable for a live stream. Answer again, keeping it friendly and stream-safe.",
+            Timestamp: time.Now(),
+        })
+
+        resp, err := l.backend.CreateChatCompletion(ctx, req)
+        if err != nil {
+            return "", err
+        }
+        return l.decodeReply(turn, resp.Content), nil
+    }
+}
+
+// decodeReply extracts the spoken text from a reply. In structured mode the
+// parsed reply is kept on the turn; if it fails validation we fall back to
+// plain text and let the emotion engine label it as usual.
+func (l *LLMProcessor) decodeReply(turn *chatTurn, raw string) string {
+    if !turn.request.JSONMode {
+        return raw
+    }
+
+    reply, err := parseStructuredReply(raw, l.config.StructuredOutput)
+    if err == nil {
+        turn.structured = reply
+        return reply.Text
+    }
+
+    log.Printf("Structured reply rejected, falling back to plain text: %v", err)
+    turn.structured = nil
+    if text := plainTextFromReply(raw); text != "" {
+        return text
+    }
+    // Never read raw JSON out loud
+    return l.brainLagResponse(err).Text
+}
+
+// brainLagResponse is the in-character line used when no backend can answer.
+// It is not added to the context window or memory.
+func (l *LLMProcessor) brainLagResponse(err error) *Response {
+    lines := l.config.Resilience.BrainLagLines
+    if len(lines) == 0 {
+        lines = defaultBrainLagLines
+    }
+    line := lines[l.brainLagCount%len(lines)]
+    l.brainLagCount++
+
+    response := &Response{
+        Text:     line,
+        Emotion:  "neutral",
+        Metadata: l.generateResponseMetadata(),
+    }
+    response.Metadata.Backend = l.backend.Name()
+    response.Metadata.BackendError = err.Error()
+    return response
+}
+
+// BackendHealth reports LLM backend state; register it with
+// StreamManager.RegisterHealthCheck
+func (l *LLMProcessor) BackendHealth() HealthStatus {
+    if reporter, ok := l.backend.(interface{ Health() HealthStatus }); ok {
+        return reporter.Health()
+    }
+    return HealthStatus{Healthy: true, Detail: l.backend.Name()}
+}
+
+// Usage returns the token and spend accounting; call WriteSummary on it
+// when the stream ends, e.g. from StreamManager.OnStop
+func (l *LLMProcessor) Usage() *UsageTracker {
+    return l.usage
+}
+
+// Memory returns the memory buffer; close it when the stream ends, e.g.
+// from StreamManager.OnStop, to keep what was learned
+func (l *LLMProcessor) Memory() *MemoryBuffer {
+    return l.memoryBuffer
+}
+
+// Emotions returns the VTuber's emotion engine
+func (l *LLMProcessor) Emotions() *EmotionEngine {
+    return l.emotionEngine
+}
+
+// ViewerProfiles returns what is known about each viewer; close it when the
+// stream ends to save last-seen times
+func (l *LLMProcessor) ViewerProfiles() *ViewerProfiles {
+    return l.profiles
+}
+
+// AudienceMood returns chat's aggregate mood, nil unless enabled
+func (l *LLMProcessor) AudienceMood() *AudienceMood {
+    return l.audience
+}
+
+// Moderation returns the output safety pipeline, e.g. to add classifiers
+func (l *LLMProcessor) Moderation() *ModerationPipeline {
+    return l.moderation
+}
+
+// SetGestures offers the avatar's animations to the model in structured mode
+// unless the config lists its own. Call it before the stream starts.
+func (l *LLMProcessor) SetGestures(names []string) {
+    if len(l.config.StructuredOutput.Gestures) == 0 {
+        l.config.StructuredOutput.Gestures = names
+    }
+}
+
+// ProcessInputStream is the streaming variant of ProcessInput. Sentence-sized
+// chunks are sent on chunks as soon as they are complete, and chunks is
+// closed before returning. The returned Response holds the assembled text.
+func (l *LLMProcessor) ProcessInputStream(ctx context.Context, viewer ViewerIdentity, input string, chunks chan<- SpeechChunk) (*Response, error) {
+    defer close(chunks)
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.usage.BeginInteraction(l.interactionCount + 1)
+
+    // Structured output can't be spoken before the whole object has arrived
+    turn := l.prepareTurn(viewer, input, false)
+
+    var spoken []string
+    chunker := NewSentenceChunker(defaultMinSentenceRunes)
+
+    emit := func(sentence string) error {
+        // A reply can't be regenerated halfway through, so anything worse
+        // than a rewrite ends it with a fallback line
+        sentence, event := l.moderation.Moderate(ctx, sentence, "stream", nil)
+        if event != nil {
+            turn.moderation = append(turn.moderation, *event)
+        }
+
+        emotion := l.emotionEngine.AnalyzeResponse(sentence)
+        state := l.emotionEngine.GetCurrentEmotionalState()
+        chunk := SpeechChunk{
+            Index:     len(spoken),
+            Text:      sentence,
+            Language:  turn.language.Spoken,
+            Emotion:   emotion,
+            Intensity: state.Intensity,
+        }
+        chunk.Secondary, chunk.Blend = state.MixFor(emotion)
+        spoken = append(spoken, sentence)
+
+        select {
+        case chunks <- chunk:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+
+        if event != nil && event.Action == ModerationFallback {
+            return errStreamStopped
+        }
+        return nil
+    }
+
+    var err error
+    if text, ok := l.cachedReply(ctx, turn); ok {
+        for _, sentence := range chunker.Write(text) {
+            if err = emit(sentence); err != nil {
+                break
+            }
+        }
+    } else {
+        err = l.streamRounds(ctx, turn, chunker, emit)
+    }
+    if err == nil {
+        if rest := chunker.Flush(); rest != "" {
+            err = emit(rest)
+        }
+    }
+    if err != nil && !errors.Is(err, errStreamStopped) {
+        if ctx.Err() != nil {
+            return nil, err
+        }
+
+        response := l.brainLagResponse(err)
+        select {
+        case chunks <- SpeechChunk{Index: len(spoken), Text: response.Text, Emotion: response.Emotion}:
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+        return response, nil
+    }
+
+    // Remember what was actually said, not what the model wrote
+    response := l.finishResponse(turn, strings.Join(spoken, " "), turn.request.Model)
+    l.cacheReply(ctx, turn, response)
+    l.addSubtitle(ctx, turn, response)
+    return response, nil
+}
+
+// cachedReply returns a recent answer to the same question given in the
+// same mood, lightly reworded if configured.
+func (l *LLMProcessor) cachedReply(ctx context.Context, turn *chatTurn) (string, bool) {
+    if l.cache == nil {
+        return "", false
+    }
+    hit, ok := l.cache.Lookup(ctx, turn.input.Content, turn.cacheScope)
+    if !ok {
+        return "", false
+    }
+    turn.cacheHit = &hit
+
+    if !l.config.ResponseCache.Rephrase {
+        return hit.Text, true
+    }
+
+    resp, err := l.rephraseBackend.CreateChatCompletion(ctx, ChatRequest{
+        Messages: []Message{
+            {Role: "system", Content: "Reword this live stream reply slightly so it doesn't sound repeated. Keep the meaning, tone and language. Reply with the new wording only.", Timestamp: time.Now()},
+            {Role: "user", Content: hit.Text, Timestamp: time.Now()},
+        },
+        Temperature: 0.9,
+        MaxTokens:   l.budget.ReplyTokens(),
+    })
+    if err != nil {
+        log.Printf("Cached reply rephrase failed: %v", err)
+        return hit.Text, true
+    }
+
+    // The cached text already passed moderation, so fall back to it
+    rephrased := strings.TrimSpace(resp.Content)
+    if _, event := l.moderation.Moderate(ctx, rephrased, "cache", nil); event != nil {
+        turn.moderation = append(turn.moderation, *event)
+        return hit.Text, true
+    }
+    return rephrased, true
+}
+
+// cacheReply stores a fresh answer unless it depended on live tool data,
+// needed moderation or triggered stream actions.
+func (l *LLMProcessor) cacheReply(ctx context.Context, turn *chatTurn, response *Response) {
+    if l.cache == nil || turn.cacheHit != nil {
+        return
+    }
+    if len(turn.toolCalls) > 0 || len(turn.moderation) > 0 || len(response.Actions) > 0 {
+        return
+    }
+    // "thanks Bob!" must not be replayed to the next viewer
+    if viewer := turn.input.Viewer; viewer != nil && addressesViewer(response.Text, l.viewerNames(*viewer)) {
+        return
+    }
+    l.cache.Store(ctx, turn.input.Content, turn.cacheScope, response.Text, response.Emotion)
+}
+
+// viewerNames lists what the reply could call the viewer by
+func (l *LLMProcessor) viewerNames(viewer ViewerIdentity) []string {
+    names := []string{viewer.Handle, viewer.DisplayName()}
+    if profile, ok := l.profiles.Get(viewer); ok {
+        names = append(names, profile.Handles...)
+        for _, nickname := range profile.Nicknames {
+            names = append(names, nickname.Text)
+        }
+    }
+    return names
+}
+
+// addSubtitle translates the reply into the viewer's language when the
+// persona answers in its main language. A failed translation only loses
+// the subtitle.
+func (l *LLMProcessor) addSubtitle(ctx context.Context, turn *chatTurn, response *Response) {
+    lang := turn.language.Subtitle
+    if lang == "" || response.Text == "" {
+        return
+    }
+
+    resp, err := l.subtitleBackend.CreateChatCompletion(ctx, ChatRequest{
+        Messages: []Message{
+            {Role: "system", Content: "Translate the user's message into " + languageNames[lang] + ". Keep the tone, emotes and names. Reply with the translation only.", Timestamp: time.Now()},
+            {Role: "user", Content: response.Text, Timestamp: time.Now()},
+        },
+        Temperature: 0.2,
+        MaxTokens:   l.budget.ReplyTokens(),
+    })
+    if err != nil {
+        log.Printf("Subtitle translation failed: %v", err)
+        return
+    }
+
+    subtitle, event := l.moderation.Moderate(ctx, strings.TrimSpace(resp.Content), "subtitle", nil)
+    if event != nil {
+        response.Metadata.Moderation = append(response.Metadata.Moderation, *event)
+        if event.Action == ModerationFallback {
+            return
+        }
+    }
+    response.Subtitle = subtitle
+    response.SubtitleLanguage = lang
+}
+
+// errStreamStopped ends a streamed reply early without failing it
+var errStreamStopped = errors.New("stream stopped")
+
+// streamRounds streams each round of the tool-call loop; anything said
+// before a tool call is spoken too.
+func (l *LLMProcessor) streamRounds(ctx context.Context, turn *chatTurn, chunker *SentenceChunker, emit func(string) error) error {
+    for round := 0; ; round++ {
+        if round >= l.maxToolRounds() {
+            turn.request.Tools = nil
+        }
+
+        toolCalls, roundText, err := l.streamRound(ctx, turn.request, chunker, emit)
+        if err != nil {
+            return err
+        }
+
+        if len(toolCalls) == 0 || turn.request.Tools == nil {
+            return nil
+        }
+        l.runToolCalls(ctx, turn, roundText, toolCalls)
+
+        // Keep the next round's text from running into this one
+        if roundText != "" {
+            for _, sentence := range chunker.Write(" ") {
+                if err := emit(sentence); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+}
+
+func (l *LLMProcessor) streamRound(ctx context.Context, req ChatRequest, chunker *SentenceChunker, emit func(string) error) ([]ToolCall, string, error) {
+    stream, err := l.backend.CreateChatCompletionStream(ctx, req)
+    if err != nil {
+        return nil, "", fmt.Errorf("LLM processing error: %w", err)
+    }
+    defer stream.Close()
+
+    var text strings.Builder
+    for {
+        token, err := stream.Recv()
+        if errors.Is(err, io.EOF) {
+            return stream.ToolCalls(), text.String(), nil
+        }
+        if err != nil {
+            return nil, text.String(), fmt.Errorf("LLM stream error: %w", err)
+        }
+
+        text.WriteString(token)
+        for _, sentence := range chunker.Write(token) {
+            if err := emit(sentence); err != nil {
+                return nil, text.String(), err
+            }
+        }
+    }
+}
+
+// runToolCalls executes the model's tool calls and appends the exchange to
+// the pending request so the next round can see the results.
+func (l *LLMProcessor) runToolCalls(ctx context.Context, turn *chatTurn, content string, calls []ToolCall) {
+    if turn.input.Viewer != nil {
+        ctx = withSpeaker(ctx, *turn.input.Viewer)
+    }
+    turn.request.Messages = append(turn.request.Messages, Message{
+        Role:      "assistant",
+        Content:   content,
         Timestamp: time.Now(),
-        Emotion:   emotion,
-        Confidence: confidence,
+        ToolCalls: calls,
     })
 
-    // Generate response with dynamic temperature
-    temp := l.calculateDynamicTemperature(emotion, confidence)
+    for _, call := range calls {
+        record := l.tools.Call(ctx, call)
+        turn.toolCalls = append(turn.toolCalls, record)
+
+        turn.request.Messages = append(turn.request.Messages, Message{
+            Role:       "tool",
+            Content:    record.Result,
+            Timestamp:  time.Now(),
+            ToolCallID: call.ID,
+        })
+    }
+}
+
+func (l *LLMProcessor) maxToolRounds() int {
+    if l.config.MaxToolRounds > 0 {
+        return l.config.MaxToolRounds
+    }
+    return defaultMaxToolRounds
+}
+
+// Tools returns the registry so callers can expose their own functions
+func (l *LLMProcessor) Tools() *ToolRegistry {
+    return l.tools
+}
+
+// chatTurn carries one viewer input through the backend call
+type chatTurn struct {
+    request    ChatRequest
+    input      Message
+    usage      ContextUsage
+    toolCalls  []ToolCallRecord
+    moderation []ModerationEvent
+    structured *StructuredReply
+    language   ReplyLanguage
+    cacheScope string
+    cacheHit   *CacheHit
+}
+
+func (l *LLMProcessor) prepareTurn(viewer ViewerIdentity, input string, structured bool) *chatTurn {
+    // Analyze input emotion
+    emotion, confidence := l.emotionEngine.AnalyzeEmotion(input)
     
-    resp, err := l.client.CreateChatCompletion(
-        ctx,
-        openai.ChatCompletionRequest{
-            Model:       l.config.Model,
-            Messages:    l.convertToOpenAIMessages(messages),
-            Temperature: temp,
-            MaxTokens:   1000,
-            TopP:        0.9,
-            PresencePenalty: 0.6,
-            FrequencyPenalty: 0.3,
+    turn := &chatTurn{
+        input: Message{
+            Role:      "user",
+            Content:   input,
+            Timestamp: time.Now(),
+            Emotion:   emotion,
+            Confidence: confidence,
+            Language:  DetectLanguage(input),
         },
-    )
-    if err != nil {
-        return nil, fmt.Errorf("LLM processing error: %w", err)
     }
+    if !viewer.IsAnonymous() {
+        turn.input.Viewer = &viewer
+        // The only place viewers are observed, tips aside
+        l.profiles.Observe(viewer, turn.input.Timestamp)
+    }
+    turn.language = l.config.Language.Choose(turn.input.Language)
+    if l.cache != nil {
+        turn.cacheScope = cacheScope(l.personality.GenerateBasePrompt().Content, l.emotionEngine.GetCurrentEmotionalState().Primary, turn.language.Spoken)
+    }
+
+    // Build context with personality injection
+    var messages []Message
+    messages, turn.usage = l.buildContextMessages(turn.input, structured, turn.language.Spoken)
+
+    // Generate response with dynamic sampling
+    sampling := l.sampling.Sample(emotion, confidence, l.emotionEngine.GetTemperatureModifier(emotion))
+
+    turn.request = ChatRequest{
+        Messages:    messages,
+        Temperature: sampling.Temperature,
+        MaxTokens:   l.budget.ReplyTokens(),
+        TopP:        sampling.TopP,
+        PresencePenalty: sampling.PresencePenalty,
+        FrequencyPenalty: sampling.FrequencyPenalty,
+        Tools:       l.tools.Definitions(),
+        JSONMode:    structured,
+    }
+    if len(turn.request.Tools) == 0 {
+        turn.request.Tools = nil
+    }
+    return turn
+}
 
+func (l *LLMProcessor) finishResponse(turn *chatTurn, text string, model string) *Response {
     // Process response
     response := &Response{
-        Text:     resp.Choices[0].Message.Content,
-        Emotion:  l.emotionEngine.AnalyzeResponse(resp.Choices[0].Message.Content),
+        Text:     text,
+        Language: turn.language.Spoken,
         Metadata: l.generateResponseMetadata(),
     }
+    if reply := turn.structured; reply != nil {
+        // The model labelled its own emotion, no second pass needed
+        response.Emotion = reply.Emotion.Label
+        response.Intensity = reply.Emotion.Intensity
+        response.Gestures = reply.Gestures
+        response.Actions = reply.Actions
+        response.Metadata.Structured = true
+    } else {
+        response.Emotion = l.emotionEngine.AnalyzeResponse(text)
+    }
+    response.Metadata.Temperature = turn.request.Temperature
+    response.Metadata.Sampling = SamplingParams{
+        Temperature:      turn.request.Temperature,
+        TopP:             turn.request.TopP,
+        PresencePenalty:  turn.request.PresencePenalty,
+        FrequencyPenalty: turn.request.FrequencyPenalty,
+    }
+    response.Metadata.ContextTokens = turn.usage
+    response.Metadata.ToolCalls = turn.toolCalls
+    response.Metadata.Moderation = turn.moderation
+    response.Metadata.Backend = l.backend.Name()
+    response.Metadata.Model = model
+    if model == "" {
+        response.Metadata.Model = l.config.Backend.Model
+    }
+    if hit := turn.cacheHit; hit != nil {
+        response.Metadata.Backend = "cache"
+        response.Metadata.Model = ""
+        response.Metadata.CacheSimilarity = hit.Similarity
+    }
 
     // Update memory and context
-    l.updateMemoryAndContext(response)
+    l.updateMemoryAndContext(turn.input, response)
     
-    return response, nil
+    return response
 }
 
-func (l *LLMProcessor) buildContextMessages() []Message {
-    var messages []Message
-    
+func (l *LLMProcessor) buildContextMessages(input Message, structured bool, language string) ([]Message, ContextUsage) {
+    // Add the speaker's own earlier exchanges
+    var thread, profile Message
+    if input.Viewer != nil {
+        profile = l.profiles.Message(*input.Viewer)
+        var windowStart time.Time
+        if len(l.contextWindow) > 0 {
+            windowStart = l.contextWindow[0].Timestamp
+        }
+        thread = l.threads.Context(*input.Viewer, windowStart)
+    }
+
     // Add personality base prompt
-    messages = append(messages, l.personality.GenerateBasePrompt())
-    
-    // Add relevant memories
-    memories := l.memoryBuffer.GetRelevantMemories(l.contextWindow)
-    messages = append(messages, memories...)
-    
-    // Add recent context
-    messages = append(messages, l.contextWindow...)
+    system := l.personality.GenerateBasePrompt()
+    system.Content += "\n\n" + languageInstruction(language)
+    if l.audience != nil {
+        if mood := l.audience.PromptLine(); mood != "" {
+            system.Content += "\n\n" + mood
+        }
+    }
+    if structured {
+        system.Content += "\n\n" + structuredOutputPrompt(l.config.StructuredOutput)
+    }
+
+    parts := ContextParts{
+        System: system,
+        // Add what happened before the current window
+        Summary: l.summarizer.Message(),
+        Thread: thread,
+        // Add what is known about the speaker
+        Profile: profile,
+        // Add relevant memories
+        Memories: l.GetRelevantMemories(input),
+        // Add recent context
+        History: l.contextWindow,
+        Input: input,
+    }
     
-    return messages
+    // Fit everything into the token budget
+    return l.budget.Allocate(parts)
 }
 
-func (l *LLMProcessor) calculateDynamicTemperature(emotion string, confidence float64) float64 {
-    baseTemp := l.config.TemperatureBase
-    
-    // Adjust temperature based on emotion and confidence
-    emotionMod := l.emotionEngine.GetTemperatureModifier(emotion)
-    confidenceMod := 0.2 * (1 - confidence)
-    
-    // Add some randomness for variety
-    randomMod := (time.Now().UnixNano() % 100) / 1000.0
-    
-    return baseTemp + emotionMod + confidenceMod + randomMod
+// GetRelevantMemories recalls memories for the input and the exchange
+// before it, best first, as system messages
+func (l *LLMProcessor) GetRelevantMemories(input Message) []Message {
+    query := input.Content
+    if n := len(l.contextWindow); n > 0 {
+        query = l.contextWindow[n-1].Content + "\n" + query
+    }
+
+    recalled := l.memoryBuffer.Recall(context.Background(), query, l.memoryBuffer.recall.Limit)
+    memories := make([]Message, 0, len(recalled))
+    for _, memory := range recalled {
+        memories = append(memories, Message{
+            Role:      "system",
+            Content:   fmt.Sprintf("You remember (%s, %s): %s", memory.Type, memory.Timestamp.Format("Jan 2"), memory.Content),
+            Timestamp: memory.Timestamp,
+        })
+    }
+    return memories
 }
 
-func (l *LLMProcessor) updateMemoryAndContext(response *Response) {
-    // Update context window
-    l.contextWindow = append(l.contextWindow, Message{
+func (l *LLMProcessor) updateMemoryAndContext(input Message, response *Response) {
+    reply := Message{
         Role:      "assistant",
         Content:   response.Text,
         Timestamp: time.Now(),
         Emotion:   response.Emotion,
         Confidence: 1.0,
-    })
-    
-    // Trim context if needed
-    if len(l.contextWindow) > l.config.ContextWindowSize {
-        l.contextWindow = l.contextWindow[1:]
     }
+
+    // Update context window and the speaker's thread
+    l.contextWindow = append(l.contextWindow, input, reply)
+    l.threads.Record(input, reply)
+    
+    // Trim context to its token budget, folding evicted turns into the summary
+    var evicted []Message
+    l.contextWindow, evicted = l.budget.TrimHistory(l.contextWindow)
+    l.summarizer.Fold(evicted)
     
-    // Update memory buffer
-    l.memoryBuffer.AddMemory(response)
+    // Update memory buffer, weighted by how strongly the reply was felt
+    importance := 0.5
+    if response.Intensity > 0 {
+        importance = response.Intensity
+    }
+    l.memoryBuffer.AddMemory(response.Text, "conversation", importance)
     
     // Update interaction count
     l.interactionCount++
@@ -148,6 +794,15 @@ type Response struct {
     Text     string
     Emotion  string
     Metadata ResponseMetadata
+
+    Language         string
+    Subtitle         string // translation for the viewer, see LanguageConfig
+    SubtitleLanguage string
+
+    // Only set when the model answered in structured mode
+    Intensity float64
+    Gestures  []string
+    Actions   []ResponseAction
 }
 
 type ResponseMetadata struct {
@@ -155,7 +810,16 @@ type ResponseMetadata struct {
     InteractionNum  int
     ContextSize     int
     Temperature     float64
+    Sampling        SamplingParams
     EmotionConfidence float64
+    Backend         string
+    Model           string
+    ContextTokens   ContextUsage
+    ToolCalls       []ToolCallRecord
+    Moderation      []ModerationEvent
+    BackendError    string
+    Structured      bool
+    CacheSimilarity float64 // set when the reply came from the response cache
 }
 
 func (l *LLMProcessor) generateResponseMetadata() ResponseMetadata {
@@ -163,7 +827,6 @@ func (l *LLMProcessor) generateResponseMetadata() ResponseMetadata {
         Timestamp:       time.Now(),
         InteractionNum:  l.interactionCount,
         ContextSize:     len(l.contextWindow),
-        Temperature:     l.config.TemperatureBase,
         EmotionConfidence: l.emotionEngine.LastConfidence,
     }
 } 
\ No newline at end of file
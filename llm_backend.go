@@ -0,0 +1,434 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+
+    "github.com/sashabaranov/go-openai"
+)
+
+// ChatBackend is the chat-completion provider LLMProcessor talks to.
+type ChatBackend interface {
+    Name() string
+    CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+    CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error)
+}
+
+// ChatStream yields content deltas as they arrive. Recv returns io.EOF once
+// the completion is finished, after which ToolCalls reports any tool calls
+// the model made and Usage the tokens billed, if the server reports them.
+type ChatStream interface {
+    Recv() (string, error)
+    ToolCalls() []ToolCall
+    Usage() TokenUsage
+    Close() error
+}
+
+type TokenUsage struct {
+    Model            string
+    PromptTokens     int
+    CompletionTokens int
+}
+
+type ChatRequest struct {
+    Model            string
+    Messages         []Message
+    Temperature      float64
+    MaxTokens        int
+    TopP             float64
+    PresencePenalty  float64
+    FrequencyPenalty float64
+    Tools            []ToolDefinition
+    JSONMode         bool // ask for a single JSON object
+    Cheap            bool // the spend budget is exceeded, use the backend's cheap model if it has one
+}
+
+type ChatResponse struct {
+    Content          string
+    ToolCalls        []ToolCall
+    Model            string
+    FinishReason     string
+    PromptTokens     int
+    CompletionTokens int
+}
+
+type BackendConfig struct {
+    Type            string // "openai", "local" or "scripted"
+    Model           string
+    BaseURL         string
+    APIKey          string
+    CheapModel      string // once the spend budget is exceeded; openai defaults to gpt-4o-mini, others keep Model
+    ScriptedReplies []string
+}
+
+func NewChatBackend(config BackendConfig, openAIKey string) (ChatBackend, error) {
+    switch config.Type {
+    case "", "openai":
+        backend := NewOpenAIBackend(openAIKey, config.Model)
+        backend.cheapModel = config.CheapModel
+        if backend.cheapModel == "" {
+            backend.cheapModel = openai.GPT4oMini
+        }
+        return backend, nil
+    case "local":
+        if config.BaseURL == "" {
+            return nil, errors.New("local backend requires a base URL")
+        }
+        backend := NewLocalBackend(config.BaseURL, config.APIKey, config.Model)
+        backend.cheapModel = config.CheapModel
+        return backend, nil
+    case "scripted":
+        return NewScriptedBackend(config.ScriptedReplies...), nil
+    default:
+        return nil, fmt.Errorf("unknown LLM backend %q", config.Type)
+    }
+}
+
+// OpenAIBackend serves both the hosted OpenAI API and any server that
+// speaks the same protocol (llama.cpp server, Ollama, vLLM, ...).
+type OpenAIBackend struct {
+    client     *openai.Client
+    model      string
+    cheapModel string // for requests marked Cheap, empty to ignore the mark
+    name       string
+}
+
+func NewOpenAIBackend(apiKey string, model string) *OpenAIBackend {
+    return &OpenAIBackend{
+        client: openai.NewClient(apiKey),
+        model:  model,
+        name:   "openai",
+    }
+}
+
+func NewLocalBackend(baseURL string, apiKey string, model string) *OpenAIBackend {
+    // Local servers usually ignore the key, but the client insists on one
+    if apiKey == "" {
+        apiKey = "local"
+    }
+    cfg := openai.DefaultConfig(apiKey)
+    cfg.BaseURL = baseURL
+
+    return &OpenAIBackend{
+        client: openai.NewClientWithConfig(cfg),
+        model:  model,
+        name:   "local",
+    }
+}
+
+func (b *OpenAIBackend) Name() string {
+    return b.name
+}
+
+func (b *OpenAIBackend) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+    resp, err := b.client.CreateChatCompletion(ctx, b.buildRequest(req))
+    if err != nil {
+        return nil, err
+    }
+    if len(resp.Choices) == 0 {
+        return nil, fmt.Errorf("%s backend returned no choices", b.name)
+    }
+
+    return &ChatResponse{
+        Content:          resp.Choices[0].Message.Content,
+        ToolCalls:        fromOpenAIToolCalls(resp.Choices[0].Message.ToolCalls),
+        Model:            resp.Model,
+        FinishReason:     string(resp.Choices[0].FinishReason),
+        PromptTokens:     resp.Usage.PromptTokens,
+        CompletionTokens: resp.Usage.CompletionTokens,
+    }, nil
+}
+
+func (b *OpenAIBackend) CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error) {
+    stream, err := b.client.CreateChatCompletionStream(ctx, b.buildStreamRequest(req))
+    if err != nil {
+        return nil, err
+    }
+    return &openAIChatStream{stream: stream, toolCalls: make(map[int]*ToolCall)}, nil
+}
+
+func (b *OpenAIBackend) buildStreamRequest(req ChatRequest) openai.ChatCompletionRequest {
+    request := b.buildRequest(req)
+    // Local servers don't all accept stream_options
+    if b.name == "openai" {
+        request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+    }
+    return request
+}
+
+func (b *OpenAIBackend) buildRequest(req ChatRequest) openai.ChatCompletionRequest {
+    model := req.Model
+    if model == "" {
+        model = b.model
+        if req.Cheap && b.cheapModel != "" {
+            model = b.cheapModel
+        }
+    }
+
+    var format *openai.ChatCompletionResponseFormat
+    if req.JSONMode {
+        format = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+    }
+
+    return openai.ChatCompletionRequest{
+        Model:            model,
+        Messages:         toOpenAIMessages(req.Messages),
+        Temperature:      float32(req.Temperature),
+        MaxTokens:        req.MaxTokens,
+        TopP:             float32(req.TopP),
+        PresencePenalty:  float32(req.PresencePenalty),
+        FrequencyPenalty: float32(req.FrequencyPenalty),
+        Tools:            toOpenAITools(req.Tools),
+        ResponseFormat:   format,
+    }
+}
+
+type openAIChatStream struct {
+    stream    *openai.ChatCompletionStream
+    toolCalls map[int]*ToolCall
+    usage     TokenUsage
+}
+
+func (s *openAIChatStream) Recv() (string, error) {
+    for {
+        resp, err := s.stream.Recv()
+        if err != nil {
+            return "", err
+        }
+        if resp.Model != "" {
+            s.usage.Model = resp.Model
+        }
+        // With include_usage the last chunk carries usage and no choices
+        if resp.Usage != nil {
+            s.usage.PromptTokens = resp.Usage.PromptTokens
+            s.usage.CompletionTokens = resp.Usage.CompletionTokens
+        }
+        if len(resp.Choices) == 0 {
+            continue
+        }
+
+        delta := resp.Choices[0].Delta
+        s.accumulateToolCalls(delta.ToolCalls)
+
+        // Skip role-only, tool-call and empty keep-alive deltas
+        if delta.Content != "" {
+            return delta.Content, nil
+        }
+    }
+}
+
+// Tool calls arrive in fragments keyed by index; the name and ID come
+// first and the JSON arguments are streamed piece by piece.
+func (s *openAIChatStream) accumulateToolCalls(deltas []openai.ToolCall) {
+    for _, delta := range deltas {
+        index := 0
+        if delta.Index != nil {
+            index = *delta.Index
+        }
+
+        call, ok := s.toolCalls[index]
+        if !ok {
+            call = &ToolCall{}
+            s.toolCalls[index] = call
+        }
+        if delta.ID != "" {
+            call.ID = delta.ID
+        }
+        if delta.Function.Name != "" {
+            call.Name = delta.Function.Name
+        }
+        call.Arguments += delta.Function.Arguments
+    }
+}
+
+func (s *openAIChatStream) ToolCalls() []ToolCall {
+    calls := make([]ToolCall, 0, len(s.toolCalls))
+    for i := 0; len(calls) < len(s.toolCalls); i++ {
+        if call, ok := s.toolCalls[i]; ok {
+            calls = append(calls, *call)
+        }
+    }
+    return calls
+}
+
+func (s *openAIChatStream) Usage() TokenUsage {
+    return s.usage
+}
+
+func (s *openAIChatStream) Close() error {
+    return s.stream.Close()
+}
+
+func toOpenAIMessages(messages []Message) []openai.ChatCompletionMessage {
+    converted := make([]openai.ChatCompletionMessage, 0, len(messages))
+    for _, msg := range messages {
+        content := msg.Content
+        // Prefix chat lines with the author so the model can tell viewers apart
+        if msg.Role == "user" && msg.Viewer != nil {
+            content = msg.Viewer.DisplayName() + ": " + content
+        }
+
+        converted = append(converted, openai.ChatCompletionMessage{
+            Role:       msg.Role,
+            Content:    content,
+            ToolCalls:  toOpenAIToolCalls(msg.ToolCalls),
+            ToolCallID: msg.ToolCallID,
+        })
+    }
+    return converted
+}
+
+func toOpenAITools(tools []ToolDefinition) []openai.Tool {
+    if len(tools) == 0 {
+        return nil
+    }
+
+    converted := make([]openai.Tool, 0, len(tools))
+    for _, tool := range tools {
+        converted = append(converted, openai.Tool{
+            Type: openai.ToolTypeFunction,
+            Function: &openai.FunctionDefinition{
+                Name:        tool.Name,
+                Description: tool.Description,
+                Parameters:  tool.Parameters,
+            },
+        })
+    }
+    return converted
+}
+
+func toOpenAIToolCalls(calls []ToolCall) []openai.ToolCall {
+    if len(calls) == 0 {
+        return nil
+    }
+
+    converted := make([]openai.ToolCall, 0, len(calls))
+    for _, call := range calls {
+        converted = append(converted, openai.ToolCall{
+            ID:   call.ID,
+            Type: openai.ToolTypeFunction,
+            Function: openai.FunctionCall{
+                Name:      call.Name,
+                Arguments: call.Arguments,
+            },
+        })
+    }
+    return converted
+}
+
+func fromOpenAIToolCalls(calls []openai.ToolCall) []ToolCall {
+    converted := make([]ToolCall, 0, len(calls))
+    for _, call := range calls {
+        converted = append(converted, ToolCall{
+            ID:        call.ID,
+            Name:      call.Function.Name,
+            Arguments: call.Function.Arguments,
+        })
+    }
+    return converted
+}
+
+// ScriptedBackend replays canned replies in order, looping once it runs
+// out. It records every request so tests can inspect what was sent.
+type ScriptedBackend struct {
+    replies  []ChatResponse
+    next     int
+    requests []ChatRequest
+    mu       sync.Mutex
+}
+
+func NewScriptedBackend(replies ...string) *ScriptedBackend {
+    b := &ScriptedBackend{}
+    b.Push(replies...)
+    return b
+}
+
+func (b *ScriptedBackend) Name() string {
+    return "scripted"
+}
+
+func (b *ScriptedBackend) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    b.requests = append(b.requests, req)
+
+    if len(b.replies) == 0 {
+        return nil, errors.New("scripted backend has no replies")
+    }
+    reply := b.replies[b.next%len(b.replies)]
+    b.next++
+
+    reply.Model = req.Model
+    return &reply, nil
+}
+
+func (b *ScriptedBackend) CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error) {
+    resp, err := b.CreateChatCompletion(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    // Stream the reply word by word, keeping the separators
+    return &scriptedChatStream{
+        tokens:    strings.SplitAfter(resp.Content, " "),
+        toolCalls: resp.ToolCalls,
+        usage:     TokenUsage{Model: resp.Model, PromptTokens: resp.PromptTokens, CompletionTokens: resp.CompletionTokens},
+    }, nil
+}
+
+type scriptedChatStream struct {
+    tokens    []string
+    toolCalls []ToolCall
+    usage     TokenUsage
+}
+
+func (s *scriptedChatStream) Recv() (string, error) {
+    if len(s.tokens) == 0 {
+        return "", io.EOF
+    }
+    token := s.tokens[0]
+    s.tokens = s.tokens[1:]
+    return token, nil
+}
+
+func (s *scriptedChatStream) ToolCalls() []ToolCall {
+    return s.toolCalls
+}
+
+func (s *scriptedChatStream) Usage() TokenUsage {
+    return s.usage
+}
+
+func (s *scriptedChatStream) Close() error {
+    return nil
+}
+
+func (b *ScriptedBackend) Push(replies ...string) {
+    for _, reply := range replies {
+        b.PushResponse(ChatResponse{Content: reply, FinishReason: "stop"})
+    }
+}
+
+// PushResponse queues a full response, e.g. one that makes tool calls
+func (b *ScriptedBackend) PushResponse(resp ChatResponse) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.replies = append(b.replies, resp)
+}
+
+func (b *ScriptedBackend) Requests() []ChatRequest {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    requests := make([]ChatRequest, len(b.requests))
+    copy(requests, b.requests)
+    return requests
+}
@@ -34,19 +34,55 @@ type StreamConfig struct {
 }
 
 type AIConfig struct {
+	Backend           BackendConfig
 	TemperatureBase    float64
 	ContextWindowSize  int
-	EmotionModel      string
+	ContextBudget     ContextBudgetConfig
+	Summary           SummaryConfig
+	MaxToolRounds     int
+	ViewerThreadSize  int // exchanges remembered per viewer
+	ViewerProfiles    ViewerProfilesConfig
+	Moderation        ModerationConfig
+	Resilience        ResilienceConfig
+	StructuredOutput  StructuredOutputConfig
+	Scheduler         SchedulerConfig
+	Usage             UsageConfig
+	Sampling          SamplingConfig
+	Language          LanguageConfig
+	ResponseCache     ResponseCacheConfig
+	EmotionModel      string // for the llm classifier, empty uses the chat model
+	EmotionClassifier string // "lexicon", "llm" or "ensemble"
+	EmotionDynamics   EmotionDynamicsConfig
+	EmotionEvents     EmotionEventsConfig
+	EmotionTimeline   string // JSON lines file every emotion update is appended to
+	EmotionTaxonomy   string // path to the emotions file, empty for the built-in one
+	Audience          AudienceConfig
 	ResponseDelay     int
 	MemoryBufferSize  int
+	Memory            MemoryConfig
 	PersonalityVector []float64
 }
 
 func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// runStream runs the VTuber live until SIGINT or SIGTERM
+func runStream() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	config := parseFlags()
+	if config.AISettings.EmotionEvents.IntensityThreshold == 0 {
+		config.AISettings.EmotionEvents.IntensityThreshold = config.EmotionThreshold
+	}
+
+	// Every component must agree on the emotion labels before anything starts
+	if err := InitEmotionTaxonomy(config.AISettings); err != nil {
+		log.Fatalf("Emotion taxonomy: %v", err)
+	}
 	
 	// Initialize components
 	llm := initializeLLM(config)
@@ -54,6 +90,7 @@ func main() {
 	voiceSynth := initializeVoiceSynthesizer(config)
 	avatarRenderer := initializeAvatarRenderer(config)
 	streamManager := initializeStreamManager(config)
+	llm.SetGestures(avatarRenderer.Gestures())
 	
 	// Create processing pipeline
 	pipeline := NewVTuberPipeline(
@@ -84,6 +121,17 @@ func main() {
 	
 	cancel()
 	wg.Wait()
+
+	// Move this stream's short-term memories to long-term storage
+	if err := llm.Memory().Close(); err != nil {
+		log.Printf("Failed to save memories: %v", err)
+	}
+	if err := llm.ViewerProfiles().Close(); err != nil {
+		log.Printf("Failed to save viewer profiles: %v", err)
+	}
+	if err := llm.Emotions().CloseTimeline(); err != nil {
+		log.Printf("Failed to close emotion timeline: %v", err)
+	}
 }
 
 // ... (continuing with more complex initialization functions) 
\ No newline at end of file
@@ -0,0 +1,204 @@
+package main
+
+import (
+    "container/heap"
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+)
+
+// MemoryFilter selects memories for List. Zero fields match everything.
+type MemoryFilter struct {
+    Type          string
+    MinImportance float64
+    Since         time.Time
+    Until         time.Time
+    PinnedOnly    bool
+    SortBy        string // "date" (newest first, the default), "importance" or "type"
+    Limit         int
+}
+
+func (f MemoryFilter) matches(memory Memory) bool {
+    if f.Type != "" && !strings.EqualFold(memory.Type, f.Type) {
+        return false
+    }
+    if memory.Importance < f.MinImportance {
+        return false
+    }
+    if !f.Since.IsZero() && memory.Timestamp.Before(f.Since) {
+        return false
+    }
+    if !f.Until.IsZero() && !memory.Timestamp.Before(f.Until) {
+        return false
+    }
+    return !f.PinnedOnly || memory.Pinned
+}
+
+func (mb *MemoryBuffer) List(filter MemoryFilter) ([]Memory, error) {
+    mb.mu.RLock()
+    var memories []Memory
+    for _, memory := range mb.allMemories() {
+        if filter.matches(memory) {
+            memories = append(memories, memory)
+        }
+    }
+    mb.mu.RUnlock()
+
+    var less func(a, b Memory) bool
+    switch filter.SortBy {
+    case "", "date":
+        less = func(a, b Memory) bool { return a.Timestamp.After(b.Timestamp) }
+    case "importance":
+        less = func(a, b Memory) bool { return a.Importance > b.Importance }
+    case "type":
+        less = func(a, b Memory) bool {
+            if a.Type != b.Type {
+                return a.Type < b.Type
+            }
+            return a.Timestamp.After(b.Timestamp)
+        }
+    default:
+        return nil, fmt.Errorf("unknown sort order %q", filter.SortBy)
+    }
+    sort.SliceStable(memories, func(i, j int) bool {
+        return less(memories[i], memories[j])
+    })
+
+    if filter.Limit > 0 && len(memories) > filter.Limit {
+        memories = memories[:filter.Limit]
+    }
+    return memories, nil
+}
+
+// Edit corrects a memory's content, its importance, or both; nil leaves a
+// field unchanged. New content is re-embedded and re-indexed.
+func (mb *MemoryBuffer) Edit(ctx context.Context, id string, content *string, importance *float64) (Memory, error) {
+    var vector []float64
+    var embeddedBy string
+    if content != nil {
+        if strings.TrimSpace(*content) == "" {
+            return Memory{}, fmt.Errorf("memory content can't be empty")
+        }
+        vector, embeddedBy = mb.embed(ctx, *content)
+    }
+
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    old, ok := mb.find(id)
+    if !ok {
+        return Memory{}, fmt.Errorf("no memory %s", id)
+    }
+    if content != nil {
+        mb.removeAssociations(old)
+    }
+
+    mb.updateMemory(id, func(m *Memory) {
+        if content != nil {
+            m.Content = *content
+            m.Embedding, m.EmbeddedBy = vector, embeddedBy
+        }
+        if importance != nil {
+            m.Importance = *importance
+        }
+    })
+    heap.Init(mb.shortTerm)
+
+    edited, _ := mb.find(id)
+    if content != nil {
+        mb.updateAssociations(edited)
+    }
+    return edited, nil
+}
+
+// Pin keeps a memory from decaying or being evicted, Unpin lets it go again
+func (mb *MemoryBuffer) Pin(id string, pinned bool) error {
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    if _, ok := mb.find(id); !ok {
+        return fmt.Errorf("no memory %s", id)
+    }
+    mb.updateMemory(id, func(m *Memory) {
+        m.Pinned = pinned
+    })
+    return nil
+}
+
+// Forget deletes a memory from every store along with the keyword
+// associations and index entries that point at it
+func (mb *MemoryBuffer) Forget(id string) error {
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    memory, ok := mb.find(id)
+    if !ok {
+        return fmt.Errorf("no memory %s", id)
+    }
+
+    for i := 0; i < len(mb.workingMemory); i++ {
+        if mb.workingMemory[i].ID == id {
+            mb.workingMemory = append(mb.workingMemory[:i], mb.workingMemory[i+1:]...)
+            i--
+        }
+    }
+    for i := 0; i < mb.shortTerm.Len(); i++ {
+        if (*mb.shortTerm)[i].ID == id {
+            heap.Remove(mb.shortTerm, i)
+            i--
+        }
+    }
+    mb.longTerm.Delete(id)
+
+    mb.removeAssociations(memory)
+    return nil
+}
+
+// find looks a memory up by ID in every store. Callers hold mb.mu.
+func (mb *MemoryBuffer) find(id string) (Memory, bool) {
+    for _, memory := range mb.workingMemory {
+        if memory.ID == id {
+            return memory, true
+        }
+    }
+    for _, memory := range *mb.shortTerm {
+        if memory.ID == id {
+            return memory, true
+        }
+    }
+    return mb.longTerm.Get(id)
+}
+
+// removeAssociations drops memory's content from its keywords' association
+// lists, removing keywords left empty. Callers hold mb.mu.
+func (mb *MemoryBuffer) removeAssociations(memory Memory) {
+    changed := make(map[string][]string)
+    for _, keyword := range extractKeywords(memory.Content) {
+        contents, ok := mb.associations[keyword]
+        if !ok {
+            continue
+        }
+
+        kept := contents[:0]
+        for _, content := range contents {
+            if content != memory.Content {
+                kept = append(kept, content)
+            }
+        }
+        if len(kept) == 0 {
+            delete(mb.associations, keyword)
+        } else {
+            mb.associations[keyword] = kept
+        }
+        changed[keyword] = kept
+    }
+
+    if len(changed) > 0 {
+        if err := mb.storage.PutAssociations(changed); err != nil {
+            log.Printf("Failed to persist memory associations: %v", err)
+        }
+    }
+}
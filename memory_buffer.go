@@ -4,6 +4,9 @@ import (
     "container/heap"
     "context"
     "encoding/json"
+    "fmt"
+    "log"
+    "math"
     "sync"
     "time"
 )
@@ -13,6 +16,11 @@ type MemoryBuffer struct {
     longTerm       *MemoryStore
     workingMemory  []Memory
     associations   map[string][]string
+    storage        MemoryStorage
+    embedder       Embedder
+    embedderGen    int
+    recall         RecallConfig
+    stop           chan struct{} // closed by Close to end maintenance
     mu             sync.RWMutex
 
     // Memory management parameters
@@ -23,15 +31,19 @@ type MemoryBuffer struct {
 }
 
 type Memory struct {
-    Content       string
-    Type          string
-    Timestamp     time.Time
-    Importance    float64
-    EmotionalTag  string
-    Associations  []string
-    AccessCount   int
-    LastAccessed  time.Time
-    Metadata      map[string]interface{}
+    ID            string                 `json:"id"`
+    Content       string                 `json:"content"`
+    Type          string                 `json:"type"`
+    Timestamp     time.Time              `json:"timestamp"`
+    Importance    float64                `json:"importance"`
+    EmotionalTag  string                 `json:"emotional_tag"`
+    Associations  []string               `json:"associations"`
+    AccessCount   int                    `json:"access_count"`
+    LastAccessed  time.Time              `json:"last_accessed"`
+    Metadata      map[string]interface{} `json:"metadata,omitempty"`
+    Pinned        bool                   `json:"pinned,omitempty"` // never decays or gets evicted
+    Embedding     []float64              `json:"embedding,omitempty"`
+    EmbeddedBy    string                 `json:"embedded_by,omitempty"`
 }
 
 type MemoryStore struct {
@@ -39,6 +51,9 @@ type MemoryStore struct {
     indices      map[string][]string
     totalSize    int
     maxSize      int
+    storage      MemoryStorage
+    vectors      *LSHIndex
+    embeddedBy   string // only vectors from this embedder are indexed
 }
 
 type MemoryHeap []Memory
@@ -60,39 +75,76 @@ func (h *MemoryHeap) Pop() interface{} {
     return x
 }
 
-func NewMemoryBuffer(config MemoryConfig) *MemoryBuffer {
+// NewMemoryBuffer loads long-term memories and associations from the
+// configured storage, so regulars are remembered across streams
+func NewMemoryBuffer(config MemoryConfig) (*MemoryBuffer, error) {
+    config = config.withDefaults()
+
+    storage, err := NewMemoryStorage(config)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open memory storage: %w", err)
+    }
+    state, err := storage.Load()
+    if err != nil {
+        storage.Close()
+        return nil, fmt.Errorf("failed to load memories: %w", err)
+    }
+
     mb := &MemoryBuffer{
         shortTerm:     &MemoryHeap{},
-        longTerm:      NewMemoryStore(config.MaxLongTerm),
+        longTerm:      NewMemoryStore(config.MaxLongTerm, storage),
         workingMemory: make([]Memory, 0, config.MaxWorking),
-        associations:  make(map[string][]string),
+        associations:  state.Associations,
+        storage:       storage,
         maxShortTerm: config.MaxShortTerm,
         maxLongTerm:  config.MaxLongTerm,
         maxWorking:   config.MaxWorking,
         decayRate:    config.DecayRate,
+        recall:       config.Recall,
+        stop:         make(chan struct{}),
     }
+    // Memories keep their vectors until SetEmbedder is called, so opening
+    // the store never re-embeds them on its own
+    mb.embedder = NewHashingEmbedder(0)
+    mb.longTerm.SetEmbedder(mb.embedder.Name())
+    mb.longTerm.load(state.Memories)
     
     heap.Init(mb.shortTerm)
     go mb.runMemoryMaintenance()
     
-    return mb
+    return mb, nil
 }
 
-func (mb *MemoryBuffer) AddMemory(content string, memType string, importance float64) {
+// Close moves short-term memories worth keeping to long-term storage and
+// closes it. Call it when the stream ends, e.g. from StreamManager.OnStop.
+func (mb *MemoryBuffer) Close() error {
     mb.mu.Lock()
     defer mb.mu.Unlock()
 
-    memory := Memory{
-        Content:      content,
-        Type:         memType,
-        Timestamp:    time.Now(),
-        Importance:   importance,
-        EmotionalTag: mb.analyzeEmotionalContent(content),
-        Associations: mb.findAssociations(content),
-        AccessCount:  1,
-        LastAccessed: time.Now(),
-        Metadata:     make(map[string]interface{}),
+    select {
+    case <-mb.stop:
+    default:
+        close(mb.stop)
+    }
+
+    threshold := mb.calculateConsolidationThreshold()
+    for mb.shortTerm.Len() > 0 {
+        memory := heap.Pop(mb.shortTerm).(Memory)
+        if memory.Pinned || memory.Importance > threshold {
+            mb.longTerm.Store(memory)
+        }
     }
+    return mb.storage.Close()
+}
+
+func (mb *MemoryBuffer) AddMemory(content string, memType string, importance float64) {
+    vector, embeddedBy := mb.embed(context.Background(), content)
+
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    memory := mb.newMemory(content, memType, importance)
+    memory.Embedding, memory.EmbeddedBy = vector, embeddedBy
 
     // Add to short-term memory
     heap.Push(mb.shortTerm, memory)
@@ -106,51 +158,57 @@ func (mb *MemoryBuffer) AddMemory(content string, memType string, importance flo
     mb.updateAssociations(memory)
 }
 
+// AddLongTermMemory stores a memory directly in long-term storage, skipping
+// short-term consolidation, and returns its ID. Used for episode summaries.
+func (mb *MemoryBuffer) AddLongTermMemory(content string, memType string, importance float64) string {
+    vector, embeddedBy := mb.embed(context.Background(), content)
+
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    memory := mb.newMemory(content, memType, importance)
+    memory.Embedding, memory.EmbeddedBy = vector, embeddedBy
+    mb.longTerm.Store(memory)
+    mb.updateAssociations(memory)
+    return memory.ID
+}
+
+func (mb *MemoryBuffer) newMemory(content string, memType string, importance float64) Memory {
+    return Memory{
+        ID:           newMemoryID(),
+        Content:      content,
+        Type:         memType,
+        Timestamp:    time.Now(),
+        Importance:   importance,
+        EmotionalTag: mb.analyzeEmotionalContent(content),
+        Associations: mb.findAssociations(content),
+        AccessCount:  1,
+        LastAccessed: time.Now(),
+        Metadata:     make(map[string]interface{}),
+    }
+}
+
 func (mb *MemoryBuffer) consolidateMemory() {
     // Move least important memories to long-term storage
     for mb.shortTerm.Len() > mb.maxShortTerm {
         memory := heap.Pop(mb.shortTerm).(Memory)
-        if memory.Importance > mb.calculateConsolidationThreshold() {
+        if memory.Pinned || memory.Importance > mb.calculateConsolidationThreshold() {
             mb.longTerm.Store(memory)
         }
     }
 }
 
-func (mb *MemoryBuffer) Recall(query string, limit int) []Memory {
-    mb.mu.Lock()
-    defer mb.mu.Unlock()
-
-    // Search through all memory stores
-    var results []Memory
-    
-    // Check working memory first
-    results = append(results, mb.searchWorkingMemory(query)...)
-    
-    // Then short-term memory
-    results = append(results, mb.searchShortTermMemory(query)...)
-    
-    // Finally, long-term memory
-    results = append(results, mb.searchLongTermMemory(query)...)
-    
-    // Sort by relevance and importance
-    mb.sortMemoriesByRelevance(results, query)
-    
-    // Update access counts and timestamps
-    mb.updateMemoryAccess(results)
-    
-    // Return limited results
-    if len(results) > limit {
-        results = results[:limit]
-    }
-    
-    return results
-}
-
 func (mb *MemoryBuffer) runMemoryMaintenance() {
     ticker := time.NewTicker(time.Hour)
     defer ticker.Stop()
 
-    for range ticker.C {
+    for {
+        select {
+        case <-mb.stop:
+            return
+        case <-ticker.C:
+        }
+
         mb.mu.Lock()
         
         // Apply memory decay
@@ -170,6 +228,9 @@ func (mb *MemoryBuffer) applyMemoryDecay() {
     // Apply decay to short-term memories
     for i := range *mb.shortTerm {
         memory := &(*mb.shortTerm)[i]
+        if memory.Pinned {
+            continue
+        }
         timeSinceAccess := time.Since(memory.LastAccessed)
         memory.Importance *= math.Exp(-mb.decayRate * timeSinceAccess.Hours())
     }
@@ -181,13 +242,17 @@ func (mb *MemoryBuffer) applyMemoryDecay() {
 func (mb *MemoryBuffer) updateAssociations(memory Memory) {
     // Extract keywords and create associations
     keywords := extractKeywords(memory.Content)
+    added := make(map[string][]string, len(keywords))
     
     for _, keyword := range keywords {
-        mb.associations[keyword] = append(mb.associations[keyword], memory.Content)
-        
         // Limit association list size
-        if len(mb.associations[keyword]) > 100 {
-            mb.associations[keyword] = mb.associations[keyword][1:]
+        mb.associations[keyword] = appendAssociations(mb.associations[keyword], []string{memory.Content})
+        added[keyword] = []string{memory.Content}
+    }
+
+    if len(added) > 0 {
+        if err := mb.storage.AddAssociations(added); err != nil {
+            log.Printf("Failed to persist memory associations: %v", err)
         }
     }
 }
\ No newline at end of file
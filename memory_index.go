@@ -0,0 +1,145 @@
+package main
+
+import (
+    "math/rand"
+)
+
+// LSHIndex finds approximate nearest neighbours by cosine similarity. Each
+// table hashes a vector to the side of a set of random hyperplanes it falls
+// on, so similar vectors tend to share a bucket in at least one table.
+type LSHIndex struct {
+    tables  int
+    bits    int
+    seed    int64
+    planes  [][][]float64 // table, bit, dimension; created on the first Add
+    buckets []map[uint64][]string
+    keys    map[string][]uint64
+}
+
+func NewLSHIndex(tables int, bits int, seed int64) *LSHIndex {
+    if tables <= 0 {
+        tables = 8
+    }
+    if bits <= 0 || bits > 64 {
+        bits = 10
+    }
+
+    buckets := make([]map[uint64][]string, tables)
+    for i := range buckets {
+        buckets[i] = make(map[uint64][]string)
+    }
+    return &LSHIndex{
+        tables:  tables,
+        bits:    bits,
+        seed:    seed,
+        buckets: buckets,
+        keys:    make(map[string][]uint64),
+    }
+}
+
+func (x *LSHIndex) Len() int {
+    return len(x.keys)
+}
+
+// Add indexes vector under id, replacing an earlier vector for it. Vectors
+// of another dimension than the first one added are ignored.
+func (x *LSHIndex) Add(id string, vector []float64) {
+    if len(vector) == 0 {
+        return
+    }
+    if x.planes == nil {
+        x.generatePlanes(len(vector))
+    }
+    if len(vector) != len(x.planes[0][0]) {
+        return
+    }
+
+    x.Remove(id)
+    keys := make([]uint64, x.tables)
+    for t := range x.planes {
+        keys[t] = x.hash(t, vector)
+        x.buckets[t][keys[t]] = append(x.buckets[t][keys[t]], id)
+    }
+    x.keys[id] = keys
+}
+
+func (x *LSHIndex) Remove(id string) {
+    keys, ok := x.keys[id]
+    if !ok {
+        return
+    }
+    for t, key := range keys {
+        ids := x.buckets[t][key]
+        for i, other := range ids {
+            if other == id {
+                ids = append(ids[:i], ids[i+1:]...)
+                break
+            }
+        }
+        if len(ids) == 0 {
+            delete(x.buckets[t], key)
+        } else {
+            x.buckets[t][key] = ids
+        }
+    }
+    delete(x.keys, id)
+}
+
+// Candidates returns the ids sharing a bucket with vector, also probing the
+// buckets one bit away so near misses across a hyperplane are found
+func (x *LSHIndex) Candidates(vector []float64) []string {
+    if x.planes == nil || len(vector) != len(x.planes[0][0]) {
+        return nil
+    }
+
+    seen := make(map[string]bool)
+    var ids []string
+    collect := func(t int, key uint64) {
+        for _, id := range x.buckets[t][key] {
+            if !seen[id] {
+                seen[id] = true
+                ids = append(ids, id)
+            }
+        }
+    }
+
+    for t := range x.planes {
+        key := x.hash(t, vector)
+        collect(t, key)
+        for b := 0; b < x.bits; b++ {
+            collect(t, key^(1<<uint(b)))
+        }
+    }
+    return ids
+}
+
+// generatePlanes is seeded so the same vectors land in the same buckets
+// every run
+func (x *LSHIndex) generatePlanes(dimensions int) {
+    rng := rand.New(rand.NewSource(x.seed))
+    x.planes = make([][][]float64, x.tables)
+    for t := range x.planes {
+        x.planes[t] = make([][]float64, x.bits)
+        for b := range x.planes[t] {
+            plane := make([]float64, dimensions)
+            for d := range plane {
+                plane[d] = rng.NormFloat64()
+            }
+            x.planes[t][b] = plane
+        }
+    }
+}
+
+func (x *LSHIndex) hash(table int, vector []float64) uint64 {
+    var key uint64
+    for b, plane := range x.planes[table] {
+        dot := 0.0
+        for d, v := range vector {
+            dot += plane[d] * v
+        }
+        if dot >= 0 {
+            key |= 1 << uint(b)
+        }
+    }
+    return key
+}
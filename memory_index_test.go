@@ -0,0 +1,129 @@
+package main
+
+import (
+    "math/rand"
+    "strconv"
+    "testing"
+)
+
+func randomVector(rng *rand.Rand, dimensions int) []float64 {
+    vector := make([]float64, dimensions)
+    for i := range vector {
+        vector[i] = rng.NormFloat64()
+    }
+    return vector
+}
+
+func containsID(ids []string, id string) bool {
+    for _, other := range ids {
+        if other == id {
+            return true
+        }
+    }
+    return false
+}
+
+func TestLSHIndex(t *testing.T) {
+    a := []float64{1, 0, 0, 0}
+    b := []float64{0, 0, 0, 1}
+
+    tests := []struct {
+        name    string
+        build   func(x *LSHIndex)
+        query   []float64
+        want    []string
+        notWant []string
+        wantLen int
+    }{
+        {
+            name:    "finds an exact match",
+            build:   func(x *LSHIndex) { x.Add("a", a); x.Add("b", b) },
+            query:   a,
+            want:    []string{"a"},
+            wantLen: 2,
+        },
+        {
+            name:    "removed ids are gone",
+            build:   func(x *LSHIndex) { x.Add("a", a); x.Remove("a") },
+            query:   a,
+            notWant: []string{"a"},
+            wantLen: 0,
+        },
+        {
+            name:    "re-adding moves the id",
+            build:   func(x *LSHIndex) { x.Add("a", a); x.Add("a", b) },
+            query:   b,
+            want:    []string{"a"},
+            wantLen: 1,
+        },
+        {
+            name:    "other dimensions are ignored",
+            build:   func(x *LSHIndex) { x.Add("a", a); x.Add("short", []float64{1, 0}) },
+            query:   a,
+            notWant: []string{"short"},
+            wantLen: 1,
+        },
+        {
+            name:    "empty vectors are ignored",
+            build:   func(x *LSHIndex) { x.Add("empty", nil) },
+            query:   a,
+            wantLen: 0,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            x := NewLSHIndex(4, 6, 1)
+            tt.build(x)
+
+            ids := x.Candidates(tt.query)
+            for _, id := range tt.want {
+                if !containsID(ids, id) {
+                    t.Errorf("candidates %v missing %s", ids, id)
+                }
+            }
+            for _, id := range tt.notWant {
+                if containsID(ids, id) {
+                    t.Errorf("candidates %v include %s", ids, id)
+                }
+            }
+            if x.Len() != tt.wantLen {
+                t.Errorf("Len() = %d, want %d", x.Len(), tt.wantLen)
+            }
+        })
+    }
+}
+
+func TestLSHIndexFindsNearNeighbours(t *testing.T) {
+    rng := rand.New(rand.NewSource(7))
+    x := NewLSHIndex(0, 0, 1)
+
+    vectors := make([][]float64, 300)
+    for i := range vectors {
+        vectors[i] = randomVector(rng, 64)
+        x.Add(strconv.Itoa(i), vectors[i])
+    }
+
+    found := 0
+    total := 0
+    for i, vector := range vectors {
+        query := make([]float64, len(vector))
+        for d := range query {
+            query[d] = vector[d] + 0.1*rng.NormFloat64()
+        }
+
+        ids := x.Candidates(query)
+        total += len(ids)
+        if containsID(ids, strconv.Itoa(i)) {
+            found++
+        }
+    }
+
+    if found < len(vectors)*95/100 {
+        t.Errorf("found %d of %d near neighbours", found, len(vectors))
+    }
+    // The index is only useful if it doesn't return everything
+    if average := total / len(vectors); average > len(vectors)/2 {
+        t.Errorf("%d candidates per query on average", average)
+    }
+}
@@ -0,0 +1,29 @@
+//go:build !unix
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// lockMemoryDir falls back to an exclusive lock file where flock isn't
+// available. A crash leaves it behind and it has to be removed by hand.
+func lockMemoryDir(dir string) (*os.File, error) {
+    path := filepath.Join(dir, "LOCK")
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+    if errors.Is(err, os.ErrExist) {
+        return nil, fmt.Errorf("memory directory %s is in use by another process (remove %s if it isn't)", dir, path)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to create memory lock: %w", err)
+    }
+    return file, nil
+}
+
+func unlockMemoryDir(file *os.File) {
+    file.Close()
+    os.Remove(file.Name())
+}
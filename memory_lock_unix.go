@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "syscall"
+)
+
+// lockMemoryDir takes an exclusive lock on dir so the stream and the memory
+// CLI never write the journal at the same time. The lock goes away with the
+// process, so a crash can't leave it stuck.
+func lockMemoryDir(dir string) (*os.File, error) {
+    file, err := os.OpenFile(filepath.Join(dir, "LOCK"), os.O_CREATE|os.O_RDWR, 0o644)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open memory lock: %w", err)
+    }
+    if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+        file.Close()
+        if errors.Is(err, syscall.EWOULDBLOCK) {
+            return nil, fmt.Errorf("memory directory %s is in use by another process", dir)
+        }
+        return nil, fmt.Errorf("failed to lock memory directory: %w", err)
+    }
+    return file, nil
+}
+
+func unlockMemoryDir(file *os.File) {
+    file.Close()
+}
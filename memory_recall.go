@@ -0,0 +1,283 @@
+package main
+
+import (
+    "context"
+    "log"
+    "math"
+    "sort"
+    "time"
+)
+
+// RecallConfig weighs what makes a memory worth recalling. Each part of the
+// score is between 0 and 1.
+type RecallConfig struct {
+    Similarity    float64       // to the query, by embedding or shared keywords
+    Importance    float64
+    Recency       float64       // halves every HalfLife since the memory was made
+    Access        float64       // how often it was recalled, relative to the others
+    HalfLife      time.Duration
+    MinSimilarity float64       // less similar memories are never recalled
+    Limit         int           // memories added to the prompt each turn
+}
+
+func (c RecallConfig) withDefaults() RecallConfig {
+    if c.Similarity == 0 && c.Importance == 0 && c.Recency == 0 && c.Access == 0 {
+        c.Similarity, c.Importance, c.Recency, c.Access = 0.6, 0.2, 0.1, 0.1
+    }
+    if c.HalfLife <= 0 {
+        c.HalfLife = 24 * time.Hour
+    }
+    if c.MinSimilarity <= 0 {
+        c.MinSimilarity = 0.1
+    }
+    if c.Limit <= 0 {
+        c.Limit = 5
+    }
+    return c
+}
+
+const embedTimeout = 10 * time.Second
+
+// SetEmbedder switches the embedder used for new memories and recall, and
+// re-embeds existing memories with it in the background
+func (mb *MemoryBuffer) SetEmbedder(embedder Embedder) {
+    go mb.reembed(embedder, mb.useEmbedder(embedder))
+}
+
+// UseEmbedder switches the embedder without re-embedding what is stored,
+// e.g. to search memories embedded by another one by keyword
+func (mb *MemoryBuffer) UseEmbedder(embedder Embedder) {
+    mb.useEmbedder(embedder)
+}
+
+func (mb *MemoryBuffer) useEmbedder(embedder Embedder) int {
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    mb.embedder = embedder
+    mb.embedderGen++
+    mb.longTerm.SetEmbedder(embedder.Name())
+    return mb.embedderGen
+}
+
+// embed returns nil when the embedder fails; the memory can then still be
+// recalled by keyword
+func (mb *MemoryBuffer) embed(ctx context.Context, text string) ([]float64, string) {
+    mb.mu.RLock()
+    embedder := mb.embedder
+    mb.mu.RUnlock()
+
+    ctx, cancel := context.WithTimeout(ctx, embedTimeout)
+    defer cancel()
+
+    vector, err := embedder.Embed(ctx, text)
+    if err != nil {
+        log.Printf("Failed to embed memory: %v", err)
+        return nil, ""
+    }
+    return vector, embedder.Name()
+}
+
+// reembed embeds every memory the current embedder hasn't, stopping when
+// the embedder is replaced again
+func (mb *MemoryBuffer) reembed(embedder Embedder, gen int) {
+    name := embedder.Name()
+
+    mb.mu.RLock()
+    var stale []Memory
+    for _, memory := range mb.allMemories() {
+        if memory.EmbeddedBy != name {
+            stale = append(stale, memory)
+        }
+    }
+    mb.mu.RUnlock()
+
+    for _, memory := range stale {
+        ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+        vector, err := embedder.Embed(ctx, memory.Content)
+        cancel()
+        if err != nil {
+            log.Printf("Stopped re-embedding memories: %v", err)
+            return
+        }
+
+        mb.mu.Lock()
+        if mb.embedderGen != gen {
+            mb.mu.Unlock()
+            return
+        }
+        mb.updateMemory(memory.ID, func(m *Memory) {
+            m.Embedding, m.EmbeddedBy = vector, name
+        })
+        mb.mu.Unlock()
+    }
+}
+
+// Recall finds the memories most relevant to query across working,
+// short-term and long-term memory. Long-term candidates come from the
+// vector index and the keyword index, so not every memory is scored.
+func (mb *MemoryBuffer) Recall(ctx context.Context, query string, limit int) []Memory {
+    vector, embeddedBy := mb.embed(ctx, query)
+
+    mb.mu.Lock()
+    defer mb.mu.Unlock()
+
+    results := mb.search(query, vector, embeddedBy, limit)
+    mb.updateMemoryAccess(results)
+    return results
+}
+
+// Search ranks memories like Recall without counting as recalling them, for
+// inspecting memory from outside the stream
+func (mb *MemoryBuffer) Search(ctx context.Context, query string, limit int) []Memory {
+    vector, embeddedBy := mb.embed(ctx, query)
+
+    mb.mu.RLock()
+    defer mb.mu.RUnlock()
+
+    return mb.search(query, vector, embeddedBy, limit)
+}
+
+// search collects and ranks candidates. Callers hold mb.mu.
+func (mb *MemoryBuffer) search(query string, vector []float64, embeddedBy string, limit int) []Memory {
+    seen := make(map[string]bool)
+    var candidates []Memory
+    add := func(memories []Memory) {
+        for _, memory := range memories {
+            if !seen[memory.ID] {
+                seen[memory.ID] = true
+                candidates = append(candidates, memory)
+            }
+        }
+    }
+    add(mb.workingMemory)
+    add(*mb.shortTerm)
+    if embeddedBy == mb.longTerm.embeddedBy {
+        add(mb.longTerm.Nearest(vector))
+    }
+    add(mb.longTerm.Search(query))
+
+    results := mb.rankMemories(candidates, query, vector, embeddedBy, time.Now())
+    if len(results) > limit {
+        results = results[:limit]
+    }
+    return results
+}
+
+func (mb *MemoryBuffer) rankMemories(candidates []Memory, query string, vector []float64, embeddedBy string, now time.Time) []Memory {
+    weights := mb.recall
+    keywords := extractKeywords(query)
+
+    maxAccess := 0
+    for _, memory := range candidates {
+        if memory.AccessCount > maxAccess {
+            maxAccess = memory.AccessCount
+        }
+    }
+
+    type scoredMemory struct {
+        memory Memory
+        score  float64
+    }
+    var scored []scoredMemory
+    for _, memory := range candidates {
+        similarity := memorySimilarity(memory, keywords, vector, embeddedBy)
+        if similarity < weights.MinSimilarity {
+            continue
+        }
+
+        age := now.Sub(memory.Timestamp)
+        recency := math.Exp(-math.Ln2 * age.Hours() / weights.HalfLife.Hours())
+        access := 0.0
+        if maxAccess > 0 {
+            access = math.Log1p(float64(memory.AccessCount)) / math.Log1p(float64(maxAccess))
+        }
+
+        score := weights.Similarity*similarity +
+            weights.Importance*clamp(memory.Importance, 0, 1) +
+            weights.Recency*clamp(recency, 0, 1) +
+            weights.Access*access
+        scored = append(scored, scoredMemory{memory: memory, score: score})
+    }
+
+    sort.SliceStable(scored, func(i, j int) bool {
+        return scored[i].score > scored[j].score
+    })
+    results := make([]Memory, len(scored))
+    for i, s := range scored {
+        results[i] = s.memory
+    }
+    return results
+}
+
+// memorySimilarity compares embeddings when both come from the same
+// embedder and falls back to the share of query keywords in the memory
+func memorySimilarity(memory Memory, keywords []string, vector []float64, embeddedBy string) float64 {
+    if embeddedBy != "" && memory.EmbeddedBy == embeddedBy && len(memory.Embedding) == len(vector) {
+        return clamp(cosineSimilarity(memory.Embedding, vector), 0, 1)
+    }
+    if len(keywords) == 0 {
+        return 0
+    }
+
+    words := make(map[string]bool)
+    for _, word := range extractKeywords(memory.Content) {
+        words[word] = true
+    }
+    shared := 0
+    for _, keyword := range keywords {
+        if words[keyword] {
+            shared++
+        }
+    }
+    return float64(shared) / float64(len(keywords))
+}
+
+func (mb *MemoryBuffer) updateMemoryAccess(memories []Memory) {
+    now := time.Now()
+    for i := range memories {
+        memories[i].AccessCount++
+        memories[i].LastAccessed = now
+
+        id := memories[i].ID
+        for j := range mb.workingMemory {
+            if mb.workingMemory[j].ID == id {
+                mb.workingMemory[j].AccessCount++
+                mb.workingMemory[j].LastAccessed = now
+            }
+        }
+        for j := range *mb.shortTerm {
+            if (*mb.shortTerm)[j].ID == id {
+                (*mb.shortTerm)[j].AccessCount++
+                (*mb.shortTerm)[j].LastAccessed = now
+            }
+        }
+        mb.longTerm.Touch(id, now)
+    }
+}
+
+// updateMemory changes a memory wherever it is kept, persisting long-term
+// changes. Callers hold mb.mu.
+func (mb *MemoryBuffer) updateMemory(id string, update func(*Memory)) {
+    for i := range mb.workingMemory {
+        if mb.workingMemory[i].ID == id {
+            update(&mb.workingMemory[i])
+        }
+    }
+    for i := range *mb.shortTerm {
+        if (*mb.shortTerm)[i].ID == id {
+            update(&(*mb.shortTerm)[i])
+        }
+    }
+    if memory, ok := mb.longTerm.Get(id); ok {
+        update(&memory)
+        mb.longTerm.Store(memory)
+    }
+}
+
+// allMemories lists every memory once. Callers hold mb.mu.
+func (mb *MemoryBuffer) allMemories() []Memory {
+    memories := append([]Memory(nil), mb.workingMemory...)
+    memories = append(memories, *mb.shortTerm...)
+    return append(memories, mb.longTerm.All()...)
+}
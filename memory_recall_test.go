@@ -0,0 +1,127 @@
+package main
+
+import (
+    "math"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMemorySimilarity(t *testing.T) {
+    keywords := extractKeywords("strawberry cake recipe")
+
+    tests := []struct {
+        name       string
+        memory     Memory
+        vector     []float64
+        embeddedBy string
+        want       float64
+    }{
+        {
+            name:       "same embedder compares vectors",
+            memory:     Memory{Content: "nothing in common", Embedding: []float64{1, 0}, EmbeddedBy: "hash"},
+            vector:     []float64{1, 0},
+            embeddedBy: "hash",
+            want:       1,
+        },
+        {
+            name:       "opposite vectors clamp to zero",
+            memory:     Memory{Content: "strawberry cake", Embedding: []float64{-1, 0}, EmbeddedBy: "hash"},
+            vector:     []float64{1, 0},
+            embeddedBy: "hash",
+            want:       0,
+        },
+        {
+            name:       "other embedder falls back to keywords",
+            memory:     Memory{Content: "likes strawberry cake", Embedding: []float64{1, 0}, EmbeddedBy: "openai:small"},
+            vector:     []float64{1, 0},
+            embeddedBy: "hash",
+            want:       2.0 / 3,
+        },
+        {
+            name:   "no vector uses keywords",
+            memory: Memory{Content: "baked a cake"},
+            want:   1.0 / 3,
+        },
+        {
+            name:   "nothing shared",
+            memory: Memory{Content: "talked about the rain"},
+            want:   0,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := memorySimilarity(tt.memory, keywords, tt.vector, tt.embeddedBy)
+            if math.Abs(got-tt.want) > 1e-9 {
+                t.Errorf("similarity = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestRankMemories(t *testing.T) {
+    now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+    tests := []struct {
+        name       string
+        candidates []Memory
+        want       []string // IDs in ranked order
+    }{
+        {
+            name: "more similar first",
+            candidates: []Memory{
+                {ID: "partial", Content: "baked a cake", Importance: 0.5, Timestamp: now},
+                {ID: "close", Content: "likes strawberry cake", Importance: 0.5, Timestamp: now},
+            },
+            want: []string{"close", "partial"},
+        },
+        {
+            name: "unrelated memories are never recalled",
+            candidates: []Memory{
+                {ID: "rain", Content: "talked about the rain", Importance: 1, Timestamp: now},
+                {ID: "cake", Content: "baked a cake", Importance: 0.1, Timestamp: now},
+            },
+            want: []string{"cake"},
+        },
+        {
+            name: "importance breaks a tie",
+            candidates: []Memory{
+                {ID: "minor", Content: "strawberry cake", Importance: 0.1, Timestamp: now},
+                {ID: "major", Content: "strawberry cake", Importance: 0.9, Timestamp: now},
+            },
+            want: []string{"major", "minor"},
+        },
+        {
+            name: "recent beats old",
+            candidates: []Memory{
+                {ID: "old", Content: "strawberry cake", Importance: 0.5, Timestamp: now.Add(-72 * time.Hour)},
+                {ID: "new", Content: "strawberry cake", Importance: 0.5, Timestamp: now.Add(-time.Hour)},
+            },
+            want: []string{"new", "old"},
+        },
+        {
+            name: "often recalled beats rarely recalled",
+            candidates: []Memory{
+                {ID: "rare", Content: "strawberry cake", Importance: 0.5, Timestamp: now, AccessCount: 1},
+                {ID: "often", Content: "strawberry cake", Importance: 0.5, Timestamp: now, AccessCount: 20},
+            },
+            want: []string{"often", "rare"},
+        },
+    }
+
+    mb := &MemoryBuffer{recall: RecallConfig{}.withDefaults()}
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ranked := mb.rankMemories(tt.candidates, "strawberry cake recipe", nil, "", now)
+
+            ids := make([]string, len(ranked))
+            for i, memory := range ranked {
+                ids[i] = memory.ID
+            }
+            if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+                t.Errorf("ranked %v, want %v", ids, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+)
+
+// Bump when the persisted layout of Memory or MemoryState changes, and add
+// the upgrade to migrateMemoryState
+const memorySchemaVersion = 1
+
+// Memories kept per keyword association, oldest dropped first
+const maxAssociations = 100
+
+type MemoryConfig struct {
+    MaxShortTerm int
+    MaxLongTerm  int     // negative for no limit
+    MaxWorking   int
+    DecayRate    float64 // importance lost per hour without access
+    Path         string  // directory for durable storage, empty keeps memories in RAM only
+    CompactEvery int     // journal entries before they are folded into the snapshot
+    Embedder     EmbedderConfig
+    Recall       RecallConfig
+}
+
+func (c MemoryConfig) withDefaults() MemoryConfig {
+    if c.MaxShortTerm <= 0 {
+        c.MaxShortTerm = 50
+    }
+    if c.MaxLongTerm == 0 {
+        c.MaxLongTerm = 5000
+    }
+    if c.MaxWorking <= 0 {
+        c.MaxWorking = 10
+    }
+    if c.DecayRate <= 0 {
+        c.DecayRate = 0.01
+    }
+    if c.CompactEvery <= 0 {
+        c.CompactEvery = 500
+    }
+    c.Recall = c.Recall.withDefaults()
+    return c
+}
+
+// MemoryState is everything that survives a restart. Keyword indices are
+// rebuilt from the memories on load so they can't drift from them.
+type MemoryState struct {
+    Version      int                 `json:"version"`
+    Sequence     uint64              `json:"sequence"` // last journal entry folded in
+    Memories     map[string]Memory   `json:"memories"`
+    Associations map[string][]string `json:"associations"`
+}
+
+func newMemoryState() MemoryState {
+    return MemoryState{
+        Version:      memorySchemaVersion,
+        Memories:     make(map[string]Memory),
+        Associations: make(map[string][]string),
+    }
+}
+
+// MemoryStorage persists long-term memories and associations
+type MemoryStorage interface {
+    Load() (MemoryState, error)
+    PutMemory(memory Memory) error
+    DeleteMemory(id string) error
+    TouchMemory(id string, accessCount int, lastAccessed time.Time) error
+    PutAssociations(associations map[string][]string) error // empty lists remove the keyword
+    AddAssociations(added map[string][]string) error        // appends to the keywords' lists
+    Close() error
+}
+
+func NewMemoryStorage(config MemoryConfig) (MemoryStorage, error) {
+    if config.Path == "" {
+        return NewInMemoryStorage(), nil
+    }
+    return NewFileMemoryStorage(config.Path, config.CompactEvery)
+}
+
+type memoryJournalEntry struct {
+    Version      int                 `json:"v"`
+    Sequence     uint64              `json:"seq"`
+    Op           string              `json:"op"` // "put", "delete", "touch", "associations" or "associate"
+    Memory       *Memory             `json:"memory,omitempty"`
+    ID           string              `json:"id,omitempty"`
+    AccessCount  int                 `json:"access_count,omitempty"`
+    LastAccessed *time.Time          `json:"last_accessed,omitempty"`
+    Associations map[string][]string `json:"associations,omitempty"`
+}
+
+func (s *MemoryState) apply(entry memoryJournalEntry) error {
+    if entry.Sequence > s.Sequence {
+        s.Sequence = entry.Sequence
+    }
+    switch entry.Op {
+    case "put":
+        if entry.Memory == nil || entry.Memory.ID == "" {
+            return errors.New("put without a memory")
+        }
+        s.Memories[entry.Memory.ID] = *entry.Memory
+    case "delete":
+        delete(s.Memories, entry.ID)
+    case "touch":
+        // The memory may have been deleted since
+        memory, ok := s.Memories[entry.ID]
+        if !ok || entry.LastAccessed == nil {
+            return nil
+        }
+        memory.AccessCount = entry.AccessCount
+        memory.LastAccessed = *entry.LastAccessed
+        s.Memories[entry.ID] = memory
+    case "associations":
+        for keyword, contents := range entry.Associations {
+            if len(contents) == 0 {
+                delete(s.Associations, keyword)
+            } else {
+                s.Associations[keyword] = contents
+            }
+        }
+    case "associate":
+        for keyword, contents := range entry.Associations {
+            s.Associations[keyword] = appendAssociations(s.Associations[keyword], contents)
+        }
+    default:
+        return fmt.Errorf("unknown journal op %q", entry.Op)
+    }
+    return nil
+}
+
+// appendAssociations adds contents to a keyword's list, keeping the newest
+// maxAssociations
+func appendAssociations(list []string, contents []string) []string {
+    list = append(list, contents...)
+    if len(list) > maxAssociations {
+        list = append([]string(nil), list[len(list)-maxAssociations:]...)
+    }
+    return list
+}
+
+// migrateMemoryState upgrades state written by an older version
+func migrateMemoryState(state *MemoryState) error {
+    if state.Version > memorySchemaVersion {
+        return fmt.Errorf("memory schema version %d is newer than supported version %d", state.Version, memorySchemaVersion)
+    }
+    // Version 0 predates versioning and has the same layout as version 1
+    if state.Memories == nil {
+        state.Memories = make(map[string]Memory)
+    }
+    if state.Associations == nil {
+        state.Associations = make(map[string][]string)
+    }
+    state.Version = memorySchemaVersion
+    return nil
+}
+
+// InMemoryStorage keeps everything in RAM, for tests and throwaway streams
+type InMemoryStorage struct {
+    state MemoryState
+    mu    sync.Mutex
+}
+
+func NewInMemoryStorage() *InMemoryStorage {
+    return &InMemoryStorage{state: newMemoryState()}
+}
+
+func (s *InMemoryStorage) Load() (MemoryState, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.state.clone(), nil
+}
+
+func (s *InMemoryStorage) PutMemory(memory Memory) error {
+    return s.apply(memoryJournalEntry{Op: "put", Memory: &memory})
+}
+
+func (s *InMemoryStorage) DeleteMemory(id string) error {
+    return s.apply(memoryJournalEntry{Op: "delete", ID: id})
+}
+
+func (s *InMemoryStorage) TouchMemory(id string, accessCount int, lastAccessed time.Time) error {
+    return s.apply(memoryJournalEntry{Op: "touch", ID: id, AccessCount: accessCount, LastAccessed: &lastAccessed})
+}
+
+func (s *InMemoryStorage) PutAssociations(associations map[string][]string) error {
+    return s.apply(memoryJournalEntry{Op: "associations", Associations: associations})
+}
+
+func (s *InMemoryStorage) AddAssociations(added map[string][]string) error {
+    return s.apply(memoryJournalEntry{Op: "associate", Associations: added})
+}
+
+func (s *InMemoryStorage) Close() error {
+    return nil
+}
+
+func (s *InMemoryStorage) apply(entry memoryJournalEntry) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.state.apply(entry)
+}
+
+func (s MemoryState) clone() MemoryState {
+    clone := newMemoryState()
+    clone.Version = s.Version
+    clone.Sequence = s.Sequence
+    for id, memory := range s.Memories {
+        clone.Memories[id] = memory
+    }
+    for keyword, contents := range s.Associations {
+        clone.Associations[keyword] = append([]string(nil), contents...)
+    }
+    return clone
+}
+
+// FileMemoryStorage writes every change to an fsynced journal and folds the
+// journal into a snapshot that is replaced by atomic rename, so a crash at
+// any point leaves either the old or the new state on disk.
+type FileMemoryStorage struct {
+    dir          string
+    compactEvery int
+    journal      *os.File
+    size         int64 // journal bytes written and synced
+    failed       error // set once a bad write couldn't be cut off the journal
+    state        MemoryState
+    entries      int
+    lock         *os.File
+    mu           sync.Mutex
+}
+
+func NewFileMemoryStorage(dir string, compactEvery int) (*FileMemoryStorage, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, fmt.Errorf("failed to create memory directory: %w", err)
+    }
+    lock, err := lockMemoryDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    return &FileMemoryStorage{dir: dir, compactEvery: compactEvery, state: newMemoryState(), lock: lock}, nil
+}
+
+func (s *FileMemoryStorage) snapshotPath() string {
+    return filepath.Join(s.dir, "memories.json")
+}
+
+func (s *FileMemoryStorage) journalPath() string {
+    return filepath.Join(s.dir, "memories.journal")
+}
+
+// Load reads the snapshot, replays the journal on top and compacts both
+// into a fresh snapshot
+func (s *FileMemoryStorage) Load() (MemoryState, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    state := newMemoryState()
+    data, err := os.ReadFile(s.snapshotPath())
+    switch {
+    case err == nil:
+        state.Version = 0
+        if err := json.Unmarshal(data, &state); err != nil {
+            return MemoryState{}, fmt.Errorf("corrupt memory snapshot: %w", err)
+        }
+        if err := migrateMemoryState(&state); err != nil {
+            return MemoryState{}, err
+        }
+    case !errors.Is(err, os.ErrNotExist):
+        return MemoryState{}, fmt.Errorf("failed to read memory snapshot: %w", err)
+    }
+
+    if err := s.replayJournal(&state); err != nil {
+        return MemoryState{}, err
+    }
+    s.state = state
+
+    if err := s.compact(); err != nil {
+        return MemoryState{}, err
+    }
+    log.Printf("Loaded %d memories from %s", len(state.Memories), s.dir)
+    return state.clone(), nil
+}
+
+func (s *FileMemoryStorage) replayJournal(state *MemoryState) error {
+    data, err := os.ReadFile(s.journalPath())
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read memory journal: %w", err)
+    }
+
+    lines := strings.Split(string(data), "\n")
+    for i, line := range lines {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+
+        var entry memoryJournalEntry
+        if err := json.Unmarshal([]byte(line), &entry); err != nil {
+            // Only the last write can be torn by a crash
+            if i == len(lines)-1 {
+                log.Printf("Dropping torn memory journal entry: %v", err)
+                return nil
+            }
+            return fmt.Errorf("corrupt memory journal line %d: %w", i+1, err)
+        }
+        if entry.Version > memorySchemaVersion {
+            return fmt.Errorf("memory journal line %d has newer schema version %d", i+1, entry.Version)
+        }
+        // Entries already in the snapshot are left over from a crash between
+        // the snapshot rename and the journal truncate
+        if entry.Sequence != 0 && entry.Sequence <= state.Sequence {
+            continue
+        }
+        if err := state.apply(entry); err != nil {
+            return fmt.Errorf("memory journal line %d: %w", i+1, err)
+        }
+    }
+    return nil
+}
+
+func (s *FileMemoryStorage) PutMemory(memory Memory) error {
+    return s.append(memoryJournalEntry{Op: "put", Memory: &memory})
+}
+
+func (s *FileMemoryStorage) DeleteMemory(id string) error {
+    return s.append(memoryJournalEntry{Op: "delete", ID: id})
+}
+
+// TouchMemory journals an access without rewriting the memory and its
+// embedding
+func (s *FileMemoryStorage) TouchMemory(id string, accessCount int, lastAccessed time.Time) error {
+    return s.append(memoryJournalEntry{Op: "touch", ID: id, AccessCount: accessCount, LastAccessed: &lastAccessed})
+}
+
+func (s *FileMemoryStorage) PutAssociations(associations map[string][]string) error {
+    return s.append(memoryJournalEntry{Op: "associations", Associations: associations})
+}
+
+// AddAssociations journals only the new contents, not the whole lists
+func (s *FileMemoryStorage) AddAssociations(added map[string][]string) error {
+    return s.append(memoryJournalEntry{Op: "associate", Associations: added})
+}
+
+func (s *FileMemoryStorage) append(entry memoryJournalEntry) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.journal == nil {
+        return errors.New("memory storage is not loaded")
+    }
+    if s.failed != nil {
+        return fmt.Errorf("memory journal stopped after a failed write: %w", s.failed)
+    }
+
+    entry.Version = memorySchemaVersion
+    entry.Sequence = s.state.Sequence + 1
+    line, err := json.Marshal(entry)
+    if err != nil {
+        return err
+    }
+    if err := s.write(append(line, '\n')); err != nil {
+        return err
+    }
+    if err := s.state.apply(entry); err != nil {
+        return err
+    }
+
+    s.entries++
+    if s.entries >= s.compactEvery {
+        return s.compact()
+    }
+    return nil
+}
+
+// write appends a line to the journal. A failed write or sync is cut back off
+// so no torn line is left for the next entry to land behind; if that fails
+// too the journal takes no more writes. Callers hold s.mu.
+func (s *FileMemoryStorage) write(line []byte) error {
+    _, err := s.journal.Write(line)
+    if err == nil {
+        err = s.journal.Sync()
+    }
+    if err == nil {
+        s.size += int64(len(line))
+        return nil
+    }
+
+    if terr := s.journal.Truncate(s.size); terr != nil {
+        s.failed = terr
+    } else if serr := s.journal.Sync(); serr != nil {
+        s.failed = serr
+    }
+    if s.failed != nil {
+        log.Printf("Memory journal can't be rolled back, stopping writes until the next snapshot: %v", s.failed)
+    }
+    return fmt.Errorf("failed to write memory journal: %w", err)
+}
+
+// compact writes the state to a temporary snapshot, syncs it, renames it over
+// the old one and only then empties the journal. Callers hold s.mu.
+func (s *FileMemoryStorage) compact() error {
+    data, err := json.Marshal(s.state)
+    if err != nil {
+        return err
+    }
+
+    tmp, err := os.CreateTemp(s.dir, "memories-*.json.tmp")
+    if err != nil {
+        return fmt.Errorf("failed to write memory snapshot: %w", err)
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return fmt.Errorf("failed to write memory snapshot: %w", err)
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return fmt.Errorf("failed to sync memory snapshot: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        return fmt.Errorf("failed to write memory snapshot: %w", err)
+    }
+    if err := os.Rename(tmp.Name(), s.snapshotPath()); err != nil {
+        return fmt.Errorf("failed to replace memory snapshot: %w", err)
+    }
+    syncDir(s.dir)
+
+    if s.journal != nil {
+        s.journal.Close()
+    }
+    s.journal, err = os.OpenFile(s.journalPath(), os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return fmt.Errorf("failed to open memory journal: %w", err)
+    }
+    s.entries = 0
+    s.size = 0
+    s.failed = nil
+    return nil
+}
+
+func (s *FileMemoryStorage) Close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.lock != nil {
+        defer func() {
+            unlockMemoryDir(s.lock)
+            s.lock = nil
+        }()
+    }
+    if s.journal == nil {
+        return nil
+    }
+    err := s.compact()
+    s.journal.Close()
+    s.journal = nil
+    return err
+}
+
+// syncDir makes a rename durable; not every platform supports it
+func syncDir(dir string) {
+    if d, err := os.Open(dir); err == nil {
+        d.Sync()
+        d.Close()
+    }
+}
+
+func newMemoryID() string {
+    var b [8]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        panic(fmt.Sprintf("failed to generate memory id: %v", err))
+    }
+    return hex.EncodeToString(b[:])
+}
+
+var memoryStopWords = map[string]bool{
+    "the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true, "your": true,
+    "all": true, "any": true, "can": true, "had": true, "her": true, "was": true, "one": true, "our": true,
+    "out": true, "has": true, "have": true, "his": true, "how": true, "its": true, "who": true, "did": true,
+    "this": true, "that": true, "with": true, "from": true, "they": true, "what": true, "when": true,
+    "were": true, "will": true, "just": true, "like": true, "about": true, "there": true, "their": true,
+}
+
+// extractKeywords returns the distinct lowercase words of text worth
+// indexing
+func extractKeywords(text string) []string {
+    seen := make(map[string]bool)
+    var keywords []string
+    for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    }) {
+        if len([]rune(word)) < 3 || memoryStopWords[word] || seen[word] {
+            continue
+        }
+        seen[word] = true
+        keywords = append(keywords, word)
+    }
+    return keywords
+}
+
+func NewMemoryStore(maxSize int, storage MemoryStorage) *MemoryStore {
+    return &MemoryStore{
+        memories: make(map[string]Memory),
+        indices:  make(map[string][]string),
+        maxSize:  maxSize,
+        storage:  storage,
+        vectors:  NewLSHIndex(0, 0, 1),
+    }
+}
+
+// SetEmbedder rebuilds the vector index from the memories embedded by the
+// named embedder; the rest are only found by keyword until re-embedded
+func (s *MemoryStore) SetEmbedder(name string) {
+    s.embeddedBy = name
+    s.vectors = NewLSHIndex(0, 0, 1)
+    for id, memory := range s.memories {
+        if memory.EmbeddedBy == name {
+            s.vectors.Add(id, memory.Embedding)
+        }
+    }
+}
+
+// Nearest returns the memories whose vectors may be close to vector
+func (s *MemoryStore) Nearest(vector []float64) []Memory {
+    ids := s.vectors.Candidates(vector)
+    results := make([]Memory, 0, len(ids))
+    for _, id := range ids {
+        results = append(results, s.memories[id])
+    }
+    return results
+}
+
+// load fills the store from persisted state without writing it back
+func (s *MemoryStore) load(memories map[string]Memory) {
+    for _, memory := range memories {
+        s.insert(memory)
+    }
+}
+
+// Store adds or replaces a memory, evicting the least important one when
+// the store is full
+func (s *MemoryStore) Store(memory Memory) {
+    if memory.ID == "" {
+        memory.ID = newMemoryID()
+    }
+    if old, ok := s.memories[memory.ID]; ok {
+        s.remove(old)
+    }
+    s.insert(memory)
+    s.persist(memory)
+
+    for s.maxSize > 0 && len(s.memories) > s.maxSize {
+        s.Delete(s.leastImportant())
+    }
+}
+
+// Touch records an access to a memory, persisting only the access count
+// and time
+func (s *MemoryStore) Touch(id string, at time.Time) bool {
+    memory, ok := s.memories[id]
+    if !ok {
+        return false
+    }
+    memory.AccessCount++
+    memory.LastAccessed = at
+    s.memories[id] = memory
+
+    if err := s.storage.TouchMemory(id, memory.AccessCount, at); err != nil {
+        log.Printf("Failed to persist access to memory %s: %v", id, err)
+    }
+    return true
+}
+
+func (s *MemoryStore) Get(id string) (Memory, bool) {
+    memory, ok := s.memories[id]
+    return memory, ok
+}
+
+func (s *MemoryStore) Delete(id string) bool {
+    memory, ok := s.memories[id]
+    if !ok {
+        return false
+    }
+    s.remove(memory)
+    if err := s.storage.DeleteMemory(id); err != nil {
+        log.Printf("Failed to delete memory %s: %v", id, err)
+    }
+    return true
+}
+
+func (s *MemoryStore) All() []Memory {
+    memories := make([]Memory, 0, len(s.memories))
+    for _, memory := range s.memories {
+        memories = append(memories, memory)
+    }
+    return memories
+}
+
+// Search returns memories sharing a keyword with query
+func (s *MemoryStore) Search(query string) []Memory {
+    seen := make(map[string]bool)
+    var results []Memory
+    for _, keyword := range extractKeywords(query) {
+        for _, id := range s.indices[keyword] {
+            if !seen[id] {
+                seen[id] = true
+                results = append(results, s.memories[id])
+            }
+        }
+    }
+    return results
+}
+
+func (s *MemoryStore) persist(memory Memory) {
+    if err := s.storage.PutMemory(memory); err != nil {
+        log.Printf("Failed to persist memory %s: %v", memory.ID, err)
+    }
+}
+
+func (s *MemoryStore) insert(memory Memory) {
+    s.memories[memory.ID] = memory
+    s.totalSize += len(memory.Content)
+    for _, keyword := range extractKeywords(memory.Content) {
+        s.indices[keyword] = append(s.indices[keyword], memory.ID)
+    }
+    if memory.EmbeddedBy != "" && memory.EmbeddedBy == s.embeddedBy {
+        s.vectors.Add(memory.ID, memory.Embedding)
+    }
+}
+
+func (s *MemoryStore) remove(memory Memory) {
+    delete(s.memories, memory.ID)
+    s.totalSize -= len(memory.Content)
+    s.vectors.Remove(memory.ID)
+    for _, keyword := range extractKeywords(memory.Content) {
+        ids := s.indices[keyword]
+        for i, id := range ids {
+            if id == memory.ID {
+                ids = append(ids[:i], ids[i+1:]...)
+                break
+            }
+        }
+        if len(ids) == 0 {
+            delete(s.indices, keyword)
+        } else {
+            s.indices[keyword] = ids
+        }
+    }
+}
+
+// leastImportant picks the eviction victim, sparing pinned memories unless
+// nothing else is left
+func (s *MemoryStore) leastImportant() string {
+    lowest, lowestImportance, lowestPinned := "", 0.0, false
+    for id, memory := range s.memories {
+        better := memory.Importance < lowestImportance
+        if memory.Pinned != lowestPinned {
+            better = lowestPinned
+        }
+        if lowest == "" || better {
+            lowest, lowestImportance, lowestPinned = id, memory.Importance, memory.Pinned
+        }
+    }
+    return lowest
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// crash drops storage without compacting, as a killed process would
+func crash(s *FileMemoryStorage) {
+    s.journal.Close()
+    s.journal = nil
+    unlockMemoryDir(s.lock)
+    s.lock = nil
+}
+
+func openMemoryStorage(t *testing.T, dir string) (*FileMemoryStorage, MemoryState) {
+    t.Helper()
+    s, err := NewFileMemoryStorage(dir, 100)
+    if err != nil {
+        t.Fatalf("NewFileMemoryStorage: %v", err)
+    }
+    state, err := s.Load()
+    if err != nil {
+        s.Close()
+        t.Fatalf("Load: %v", err)
+    }
+    return s, state
+}
+
+func TestFileMemoryStorageReplaysJournalAfterCrash(t *testing.T) {
+    dir := t.TempDir()
+    s, _ := openMemoryStorage(t, dir)
+
+    touched := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    steps := []error{
+        s.PutMemory(Memory{ID: "a", Content: "likes strawberry cake", Embedding: []float64{1, 0}}),
+        s.PutMemory(Memory{ID: "b", Content: "hates rain"}),
+        s.DeleteMemory("b"),
+        s.TouchMemory("a", 3, touched),
+        s.TouchMemory("b", 1, touched),
+        s.AddAssociations(map[string][]string{"cake": {"likes strawberry cake"}}),
+        s.AddAssociations(map[string][]string{"cake": {"baked a cake"}}),
+    }
+    for i, err := range steps {
+        if err != nil {
+            t.Fatalf("step %d: %v", i, err)
+        }
+    }
+    crash(s)
+
+    s, state := openMemoryStorage(t, dir)
+    defer s.Close()
+
+    if len(state.Memories) != 1 {
+        t.Fatalf("got %d memories, want 1", len(state.Memories))
+    }
+    a := state.Memories["a"]
+    if a.AccessCount != 3 || !a.LastAccessed.Equal(touched) {
+        t.Errorf("touch not replayed: access count %d, last accessed %v", a.AccessCount, a.LastAccessed)
+    }
+    if len(a.Embedding) != 2 {
+        t.Errorf("touch lost the embedding: %v", a.Embedding)
+    }
+    if got := strings.Join(state.Associations["cake"], "|"); got != "likes strawberry cake|baked a cake" {
+        t.Errorf("cake associations = %q", got)
+    }
+}
+
+func TestFileMemoryStorageSkipsCompactedJournal(t *testing.T) {
+    dir := t.TempDir()
+    s, _ := openMemoryStorage(t, dir)
+
+    steps := []error{
+        s.PutMemory(Memory{ID: "a", Content: "likes strawberry cake"}),
+        s.TouchMemory("a", 2, time.Now()),
+        s.AddAssociations(map[string][]string{"cake": {"likes strawberry cake"}}),
+    }
+    for i, err := range steps {
+        if err != nil {
+            t.Fatalf("step %d: %v", i, err)
+        }
+    }
+    journal, err := os.ReadFile(s.journalPath())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+
+    // A crash after the snapshot rename leaves the old journal behind
+    writeFile(t, filepath.Join(dir, "memories.journal"), string(journal))
+
+    s, state := openMemoryStorage(t, dir)
+    if got := strings.Join(state.Associations["cake"], "|"); got != "likes strawberry cake" {
+        t.Errorf("cake associations = %q", got)
+    }
+    if got := state.Memories["a"].AccessCount; got != 2 {
+        t.Errorf("access count = %d, want 2", got)
+    }
+
+    // New entries after the reload still replay
+    if err := s.AddAssociations(map[string][]string{"cake": {"baked a cake"}}); err != nil {
+        t.Fatal(err)
+    }
+    crash(s)
+
+    s, state = openMemoryStorage(t, dir)
+    defer s.Close()
+    if got := strings.Join(state.Associations["cake"], "|"); got != "likes strawberry cake|baked a cake" {
+        t.Errorf("cake associations after crash = %q", got)
+    }
+}
+
+func TestFileMemoryStorageStopsAfterFailedWrite(t *testing.T) {
+    dir := t.TempDir()
+    s, _ := openMemoryStorage(t, dir)
+
+    if err := s.PutMemory(Memory{ID: "a", Content: "first"}); err != nil {
+        t.Fatal(err)
+    }
+
+    // A read-only handle fails both the write and the rollback
+    journal, err := os.Open(s.journalPath())
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.journal.Close()
+    s.journal = journal
+
+    if err := s.PutMemory(Memory{ID: "b", Content: "second"}); err == nil {
+        t.Fatal("write to a read-only journal succeeded")
+    }
+    if _, ok := s.state.Memories["b"]; ok {
+        t.Error("failed write was applied")
+    }
+    if err := s.PutMemory(Memory{ID: "c", Content: "third"}); err == nil || !strings.Contains(err.Error(), "stopped") {
+        t.Errorf("write after a failed rollback = %v, want stopped", err)
+    }
+    crash(s)
+
+    s, state := openMemoryStorage(t, dir)
+    defer s.Close()
+    if len(state.Memories) != 1 {
+        t.Errorf("got %d memories, want 1", len(state.Memories))
+    }
+}
+
+func TestFileMemoryStorageJournalRecovery(t *testing.T) {
+    snapshot := `{"version":1,"memories":{"a":{"id":"a","content":"first"}},"associations":{}}`
+    put := `{"v":1,"op":"put","memory":{"id":"b","content":"second"}}`
+
+    tests := []struct {
+        name    string
+        journal string
+        want    []string // memory IDs after load
+        wantErr string
+    }{
+        {name: "clean", journal: put + "\n", want: []string{"a", "b"}},
+        {name: "torn last write", journal: put + "\n" + `{"v":1,"op":"put","mem`, want: []string{"a", "b"}},
+        {name: "torn only write", journal: `{"v":1,"op":"de`, want: []string{"a"}},
+        {name: "corrupt middle line", journal: `{"v":1,"op` + "\n" + put + "\n", wantErr: "corrupt memory journal line 1"},
+        {name: "newer schema", journal: `{"v":2,"op":"put","memory":{"id":"b"}}` + "\n", wantErr: "newer schema version 2"},
+        {name: "unknown op", journal: `{"v":1,"op":"explode"}` + "\n", wantErr: "unknown journal op"},
+        {name: "put without memory", journal: `{"v":1,"op":"put"}` + "\n", wantErr: "put without a memory"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeFile(t, filepath.Join(dir, "memories.json"), snapshot)
+            writeFile(t, filepath.Join(dir, "memories.journal"), tt.journal)
+
+            s, err := NewFileMemoryStorage(dir, 100)
+            if err != nil {
+                t.Fatalf("NewFileMemoryStorage: %v", err)
+            }
+            defer s.Close()
+
+            state, err := s.Load()
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            if len(state.Memories) != len(tt.want) {
+                t.Fatalf("got %d memories, want %v", len(state.Memories), tt.want)
+            }
+            for _, id := range tt.want {
+                if _, ok := state.Memories[id]; !ok {
+                    t.Errorf("memory %s missing", id)
+                }
+            }
+        })
+    }
+}
+
+func TestFileMemoryStorageMigratesSnapshot(t *testing.T) {
+    tests := []struct {
+        name     string
+        snapshot string
+        wantErr  string
+    }{
+        {name: "unversioned", snapshot: `{"memories":{"a":{"id":"a","content":"old"}}}`},
+        {name: "no memories", snapshot: `{"version":1}`},
+        {name: "current", snapshot: `{"version":1,"memories":{"a":{"id":"a"}},"associations":{"old":["old"]}}`},
+        {name: "newer", snapshot: `{"version":2,"memories":{}}`, wantErr: "newer than supported"},
+        {name: "corrupt", snapshot: `{"version":`, wantErr: "corrupt memory snapshot"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeFile(t, filepath.Join(dir, "memories.json"), tt.snapshot)
+
+            s, err := NewFileMemoryStorage(dir, 100)
+            if err != nil {
+                t.Fatalf("NewFileMemoryStorage: %v", err)
+            }
+            defer s.Close()
+
+            state, err := s.Load()
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            if state.Version != memorySchemaVersion {
+                t.Errorf("version = %d, want %d", state.Version, memorySchemaVersion)
+            }
+            if state.Memories == nil || state.Associations == nil {
+                t.Errorf("migrated state has nil maps: %+v", state)
+            }
+        })
+    }
+}
+
+func TestFileMemoryStorageLocksDirectory(t *testing.T) {
+    dir := t.TempDir()
+    s, _ := openMemoryStorage(t, dir)
+
+    if other, err := NewFileMemoryStorage(dir, 100); err == nil {
+        other.Close()
+        t.Fatal("second storage opened a locked directory")
+    }
+
+    if err := s.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+    s, _ = openMemoryStorage(t, dir)
+    s.Close()
+}
+
+func TestAppendAssociationsKeepsNewest(t *testing.T) {
+    var list []string
+    for i := 0; i < maxAssociations+5; i++ {
+        list = appendAssociations(list, []string{string(rune('a' + i%26))})
+    }
+    if len(list) != maxAssociations {
+        t.Fatalf("len = %d, want %d", len(list), maxAssociations)
+    }
+    if list[len(list)-1] != string(rune('a'+(maxAssociations+4)%26)) {
+        t.Errorf("newest association dropped: %q", list[len(list)-1])
+    }
+}
+
+func writeFile(t *testing.T, path string, content string) {
+    t.Helper()
+    if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+
+    "github.com/sashabaranov/go-openai"
+)
+
+type ModerationAction string
+
+// Ordered from mildest to strongest; the strongest hit wins
+const (
+    ModerationRewrite    ModerationAction = "rewrite"
+    ModerationRegenerate ModerationAction = "regenerate"
+    ModerationFallback   ModerationAction = "fallback"
+)
+
+var defaultFallbackLines = []string{
+    "Ehehe, let's talk about something else!",
+    "Hmm, my brain just blue-screened on that one. Next question!",
+    "Nope, not going there~ What else is up, chat?",
+}
+
+type ModerationRule struct {
+    Name        string
+    Words       []string // blocklist, matched case-insensitively as whole words
+    Pattern     string   // regular expression
+    Action      ModerationAction
+    Replacement string   // used by rewrite, defaults to "***"
+}
+
+type ModerationConfig struct {
+    Rules               []ModerationRule
+    ClassifierAction    ModerationAction
+    ClassifierThreshold float64
+    MaxRegenerations    int
+    FallbackLines       []string
+    LogPath             string // JSON lines file for later review
+}
+
+// ContentClassifier is a pluggable model that scores text for unsafe content
+type ContentClassifier interface {
+    Name() string
+    Classify(ctx context.Context, text string) (ClassifierVerdict, error)
+}
+
+type ClassifierVerdict struct {
+    Flagged  bool
+    Category string
+    Score    float64
+}
+
+// ModerationEvent records one intervention for later review
+type ModerationEvent struct {
+    Timestamp time.Time        `json:"timestamp"`
+    Action    ModerationAction `json:"action"`
+    Reasons   []string         `json:"reasons"`
+    Original  string           `json:"original"`
+    Final     string           `json:"final"`
+    Stage     string           `json:"stage"`
+}
+
+type moderationHit struct {
+    reason string
+    action ModerationAction
+    rule   *compiledRule
+}
+
+type compiledRule struct {
+    ModerationRule
+    pattern *regexp.Regexp
+}
+
+// ModerationPipeline checks every reply before it is spoken on stream
+type ModerationPipeline struct {
+    rules       []compiledRule
+    classifiers []ContentClassifier
+    config      ModerationConfig
+    logFile     *os.File
+    mu          sync.Mutex
+
+    events       []ModerationEvent
+    nextFallback int
+}
+
+func NewModerationPipeline(config ModerationConfig) (*ModerationPipeline, error) {
+    if config.ClassifierAction == "" {
+        config.ClassifierAction = ModerationRegenerate
+    }
+    if config.ClassifierThreshold <= 0 {
+        config.ClassifierThreshold = 0.5
+    }
+    if config.MaxRegenerations <= 0 {
+        config.MaxRegenerations = 1
+    }
+    if len(config.FallbackLines) == 0 {
+        config.FallbackLines = defaultFallbackLines
+    }
+
+    p := &ModerationPipeline{config: config}
+
+    for _, rule := range config.Rules {
+        compiled, err := compileModerationRule(rule)
+        if err != nil {
+            return nil, fmt.Errorf("moderation rule %q: %w", rule.Name, err)
+        }
+        p.rules = append(p.rules, compiled)
+    }
+
+    if config.LogPath != "" {
+        file, err := os.OpenFile(config.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+        if err != nil {
+            return nil, fmt.Errorf("failed to open moderation log: %w", err)
+        }
+        p.logFile = file
+    }
+
+    return p, nil
+}
+
+func compileModerationRule(rule ModerationRule) (compiledRule, error) {
+    switch rule.Action {
+    case ModerationRewrite, ModerationRegenerate, ModerationFallback:
+    case "":
+        rule.Action = ModerationRewrite
+    default:
+        return compiledRule{}, fmt.Errorf("unknown action %q", rule.Action)
+    }
+    if rule.Replacement == "" {
+        rule.Replacement = "***"
+    }
+
+    var alternatives []string
+    for _, word := range rule.Words {
+        quoted := regexp.QuoteMeta(word)
+        // \b only understands ASCII word characters
+        if isASCIIWord(word) {
+            quoted = `\b` + quoted + `\b`
+        }
+        alternatives = append(alternatives, quoted)
+    }
+    if rule.Pattern != "" {
+        alternatives = append(alternatives, "(?:"+rule.Pattern+")")
+    }
+    if len(alternatives) == 0 {
+        return compiledRule{}, errors.New("rule needs words or a pattern")
+    }
+
+    pattern, err := regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+    if err != nil {
+        return compiledRule{}, err
+    }
+
+    return compiledRule{ModerationRule: rule, pattern: pattern}, nil
+}
+
+func isASCIIWord(word string) bool {
+    for _, r := range word {
+        if r > unicode.MaxASCII {
+            return false
+        }
+    }
+    return true
+}
+
+func (p *ModerationPipeline) AddClassifier(classifier ContentClassifier) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.classifiers = append(p.classifiers, classifier)
+}
+
+// Moderate returns the text that is safe to speak. regenerate asks the LLM
+// for a new reply; pass nil where regeneration is impossible (e.g. halfway
+// through a streamed reply) and a fallback line is used instead.
+func (p *ModerationPipeline) Moderate(ctx context.Context, text string, stage string, regenerate func(context.Context) (string, error)) (string, *ModerationEvent) {
+    hits := p.check(ctx, text)
+    if len(hits) == 0 {
+        return text, nil
+    }
+
+    event := &ModerationEvent{
+        Timestamp: time.Now(),
+        Action:    strongestAction(hits),
+        Reasons:   hitReasons(hits),
+        Original:  text,
+        Stage:     stage,
+    }
+
+    final := ""
+    switch event.Action {
+    case ModerationRewrite:
+        final = rewriteText(text, hits)
+        // A classifier may still object to the rewritten text
+        if len(p.check(ctx, final)) > 0 {
+            event.Action = ModerationFallback
+            final = p.fallbackLine()
+        }
+
+    case ModerationRegenerate:
+        if regenerate != nil {
+            for attempt := 0; attempt < p.config.MaxRegenerations && final == ""; attempt++ {
+                candidate, err := regenerate(ctx)
+                if err != nil {
+                    log.Printf("Moderation regenerate failed: %v", err)
+                    break
+                }
+                if len(p.check(ctx, candidate)) == 0 {
+                    final = candidate
+                }
+            }
+        }
+        if final == "" {
+            event.Action = ModerationFallback
+            final = p.fallbackLine()
+        }
+
+    default:
+        final = p.fallbackLine()
+    }
+
+    event.Final = final
+    p.record(*event)
+    return final, event
+}
+
+func (p *ModerationPipeline) check(ctx context.Context, text string) []moderationHit {
+    var hits []moderationHit
+
+    for i := range p.rules {
+        rule := &p.rules[i]
+        if rule.pattern.MatchString(text) {
+            hits = append(hits, moderationHit{
+                reason: "rule:" + rule.Name,
+                action: rule.Action,
+                rule:   rule,
+            })
+        }
+    }
+
+    p.mu.Lock()
+    classifiers := p.classifiers
+    p.mu.Unlock()
+
+    for _, classifier := range classifiers {
+        verdict, err := classifier.Classify(ctx, text)
+        if err != nil {
+            // Rules still apply when a remote classifier is down
+            log.Printf("Moderation classifier %s failed: %v", classifier.Name(), err)
+            continue
+        }
+        if verdict.Flagged || verdict.Score >= p.config.ClassifierThreshold {
+            hits = append(hits, moderationHit{
+                reason: fmt.Sprintf("%s:%s", classifier.Name(), verdict.Category),
+                action: p.config.ClassifierAction,
+            })
+        }
+    }
+
+    return hits
+}
+
+// MatchesRules reports whether text trips any rule. Classifiers are skipped
+// so it's cheap enough to run on every chat message.
+func (p *ModerationPipeline) MatchesRules(text string) bool {
+    for i := range p.rules {
+        if p.rules[i].pattern.MatchString(text) {
+            return true
+        }
+    }
+    return false
+}
+
+func (p *ModerationPipeline) fallbackLine() string {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    line := p.config.FallbackLines[p.nextFallback%len(p.config.FallbackLines)]
+    p.nextFallback++
+    return line
+}
+
+func (p *ModerationPipeline) record(event ModerationEvent) {
+    log.Printf("Moderation %s at %s: %s", event.Action, event.Stage, strings.Join(event.Reasons, ", "))
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.events = append(p.events, event)
+    if len(p.events) > 500 {
+        p.events = p.events[1:]
+    }
+
+    if p.logFile != nil {
+        line, err := json.Marshal(event)
+        if err == nil {
+            _, err = p.logFile.Write(append(line, '\n'))
+        }
+        if err != nil {
+            log.Printf("Failed to write moderation log: %v", err)
+        }
+    }
+}
+
+// Interventions returns the most recent moderation events, oldest first
+func (p *ModerationPipeline) Interventions() []ModerationEvent {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    events := make([]ModerationEvent, len(p.events))
+    copy(events, p.events)
+    return events
+}
+
+func (p *ModerationPipeline) Close() error {
+    if p.logFile == nil {
+        return nil
+    }
+    return p.logFile.Close()
+}
+
+func strongestAction(hits []moderationHit) ModerationAction {
+    rank := map[ModerationAction]int{ModerationRewrite: 1, ModerationRegenerate: 2, ModerationFallback: 3}
+
+    strongest := ModerationRewrite
+    for _, hit := range hits {
+        if rank[hit.action] > rank[strongest] {
+            strongest = hit.action
+        }
+    }
+    return strongest
+}
+
+func hitReasons(hits []moderationHit) []string {
+    reasons := make([]string, 0, len(hits))
+    for _, hit := range hits {
+        reasons = append(reasons, hit.reason)
+    }
+    return reasons
+}
+
+func rewriteText(text string, hits []moderationHit) string {
+    for _, hit := range hits {
+        if hit.rule != nil {
+            text = hit.rule.pattern.ReplaceAllLiteralString(text, hit.rule.Replacement)
+        }
+    }
+    return text
+}
+
+// OpenAIModerationClassifier uses the hosted moderation endpoint
+type OpenAIModerationClassifier struct {
+    client *openai.Client
+}
+
+func NewOpenAIModerationClassifier(apiKey string) *OpenAIModerationClassifier {
+    return &OpenAIModerationClassifier{client: openai.NewClient(apiKey)}
+}
+
+func (c *OpenAIModerationClassifier) Name() string {
+    return "openai"
+}
+
+func (c *OpenAIModerationClassifier) Classify(ctx context.Context, text string) (ClassifierVerdict, error) {
+    resp, err := c.client.Moderations(ctx, openai.ModerationRequest{Input: text})
+    if err != nil {
+        return ClassifierVerdict{}, err
+    }
+    if len(resp.Results) == 0 {
+        return ClassifierVerdict{}, nil
+    }
+    result := resp.Results[0]
+
+    // Category scores are a struct of named fields; walk them generically
+    var scores map[string]float64
+    encoded, err := json.Marshal(result.CategoryScores)
+    if err == nil {
+        err = json.Unmarshal(encoded, &scores)
+    }
+    if err != nil {
+        return ClassifierVerdict{}, err
+    }
+
+    verdict := ClassifierVerdict{Flagged: result.Flagged}
+    for category, score := range scores {
+        if score > verdict.Score {
+            verdict.Category = category
+            verdict.Score = score
+        }
+    }
+    return verdict, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+)
+
+// substringClassifier flags any text containing trigger
+type substringClassifier struct {
+    trigger string
+}
+
+func (c substringClassifier) Name() string {
+    return "fake"
+}
+
+func (c substringClassifier) Classify(ctx context.Context, text string) (ClassifierVerdict, error) {
+    if strings.Contains(text, c.trigger) {
+        return ClassifierVerdict{Flagged: true, Category: "test", Score: 1}, nil
+    }
+    return ClassifierVerdict{}, nil
+}
+
+func TestCompileModerationRule(t *testing.T) {
+    tests := []struct {
+        name    string
+        rule    ModerationRule
+        wantErr bool
+    }{
+        {name: "words", rule: ModerationRule{Words: []string{"darn"}}},
+        {name: "pattern", rule: ModerationRule{Pattern: `\d{3}-\d{4}`, Action: ModerationFallback}},
+        {name: "unknown action", rule: ModerationRule{Words: []string{"darn"}, Action: "explode"}, wantErr: true},
+        {name: "nothing to match", rule: ModerationRule{Name: "empty"}, wantErr: true},
+        {name: "bad pattern", rule: ModerationRule{Pattern: "("}, wantErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := compileModerationRule(tt.rule)
+            if (err != nil) != tt.wantErr {
+                t.Errorf("err = %v, want error %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestModerationPipeline(t *testing.T) {
+    rules := []ModerationRule{
+        {Name: "mild", Words: []string{"darn", "バカ"}, Action: ModerationRewrite},
+        {Name: "phone", Pattern: `\d{3}-\d{4}`, Action: ModerationFallback},
+        {Name: "spoiler", Words: []string{"ending"}, Action: ModerationRegenerate},
+    }
+    fallback := "let's talk about something else"
+
+    regenerateWith := func(reply string, err error) func(context.Context) (string, error) {
+        return func(context.Context) (string, error) {
+            return reply, err
+        }
+    }
+
+    tests := []struct {
+        name       string
+        text       string
+        classifier string // trigger for a classifier, if any
+        regenerate func(context.Context) (string, error)
+        want       string
+        wantAction ModerationAction // empty when the text passes
+    }{
+        {name: "clean", text: "what a classy stream", want: "what a classy stream"},
+        {name: "whole words only", text: "darned socks", want: "darned socks"},
+        {name: "rewrite", text: "Darn, I lost", want: "***, I lost", wantAction: ModerationRewrite},
+        {name: "rewrite non-ASCII", text: "おまえはバカだ", want: "おまえは***だ", wantAction: ModerationRewrite},
+        {name: "fallback rule", text: "call 555-1234", want: fallback, wantAction: ModerationFallback},
+        {name: "strongest action wins", text: "darn, call 555-1234", want: fallback, wantAction: ModerationFallback},
+        {
+            name:       "regenerated",
+            text:       "the ending is sad",
+            regenerate: regenerateWith("no spoilers here", nil),
+            want:       "no spoilers here",
+            wantAction: ModerationRegenerate,
+        },
+        {
+            name:       "regenerated reply still unsafe",
+            text:       "the ending is sad",
+            regenerate: regenerateWith("the ending is happy", nil),
+            want:       fallback,
+            wantAction: ModerationFallback,
+        },
+        {
+            name:       "regeneration failed",
+            text:       "the ending is sad",
+            regenerate: regenerateWith("", errors.New("backend down")),
+            want:       fallback,
+            wantAction: ModerationFallback,
+        },
+        {name: "can't regenerate mid-stream", text: "the ending is sad", want: fallback, wantAction: ModerationFallback},
+        {
+            name:       "classifier objects to the rewrite",
+            text:       "darn it, evil plan",
+            classifier: "evil",
+            want:       fallback,
+            wantAction: ModerationFallback,
+        },
+        {
+            name:       "classifier regenerates",
+            text:       "evil plan",
+            classifier: "evil",
+            regenerate: regenerateWith("nice plan", nil),
+            want:       "nice plan",
+            wantAction: ModerationRegenerate,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p, err := NewModerationPipeline(ModerationConfig{Rules: rules, FallbackLines: []string{fallback}})
+            if err != nil {
+                t.Fatalf("NewModerationPipeline: %v", err)
+            }
+            if tt.classifier != "" {
+                p.AddClassifier(substringClassifier{trigger: tt.classifier})
+            }
+
+            got, event := p.Moderate(context.Background(), tt.text, "test", tt.regenerate)
+            if got != tt.want {
+                t.Errorf("Moderate(%q) = %q, want %q", tt.text, got, tt.want)
+            }
+
+            var action ModerationAction
+            if event != nil {
+                action = event.Action
+            }
+            if action != tt.wantAction {
+                t.Errorf("action = %q, want %q", action, tt.wantAction)
+            }
+            if recorded := len(p.Interventions()) > 0; recorded != (event != nil) {
+                t.Errorf("intervention recorded %v, event %v", recorded, event)
+            }
+        })
+    }
+}
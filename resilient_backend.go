@@ -0,0 +1,346 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "math/rand"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/sashabaranov/go-openai"
+)
+
+// ErrBackendUnavailable is returned once every backend has failed or has
+// its circuit breaker open.
+var ErrBackendUnavailable = errors.New("all LLM backends unavailable")
+
+var defaultBrainLagLines = []string{
+    "Ah, brb chat, brain lag! Give me a sec~",
+    "Uwaa, my thoughts are buffering... hold on!",
+    "Sorry sorry, my brain dropped a few frames. Ask me again in a moment!",
+}
+
+type RetryPolicy struct {
+    MaxAttempts int
+    BaseDelay   time.Duration
+    MaxDelay    time.Duration
+}
+
+type CircuitBreakerConfig struct {
+    FailureThreshold int           // consecutive failures before tripping
+    OpenDuration     time.Duration // how long to wait before probing again
+}
+
+type ResilienceConfig struct {
+    Retry         RetryPolicy
+    Breaker       CircuitBreakerConfig
+    Fallback      BackendConfig // a model name alone reuses the primary backend
+    BrainLagLines []string
+}
+
+type CircuitState string
+
+const (
+    CircuitClosed   CircuitState = "closed"
+    CircuitOpen     CircuitState = "open"
+    CircuitHalfOpen CircuitState = "half-open"
+)
+
+type CircuitBreaker struct {
+    config   CircuitBreakerConfig
+    state    CircuitState
+    failures int
+    openedAt time.Time
+    probing  bool
+    mu       sync.Mutex
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+    if config.FailureThreshold <= 0 {
+        config.FailureThreshold = 5
+    }
+    if config.OpenDuration <= 0 {
+        config.OpenDuration = 30 * time.Second
+    }
+
+    return &CircuitBreaker{
+        config: config,
+        state:  CircuitClosed,
+    }
+}
+
+// Allow reports whether a call may go through. After OpenDuration an open
+// breaker lets a single probe call through in the half-open state.
+func (cb *CircuitBreaker) Allow() bool {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    switch cb.state {
+    case CircuitOpen:
+        if time.Since(cb.openedAt) < cb.config.OpenDuration {
+            return false
+        }
+        cb.state = CircuitHalfOpen
+        cb.probing = true
+        return true
+    case CircuitHalfOpen:
+        if cb.probing {
+            return false
+        }
+        cb.probing = true
+        return true
+    default:
+        return true
+    }
+}
+
+func (cb *CircuitBreaker) RecordSuccess() {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    cb.state = CircuitClosed
+    cb.failures = 0
+    cb.probing = false
+}
+
+func (cb *CircuitBreaker) RecordFailure() {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    cb.failures++
+    cb.probing = false
+    if cb.state == CircuitHalfOpen || cb.failures >= cb.config.FailureThreshold {
+        if cb.state != CircuitOpen {
+            log.Printf("Circuit breaker tripped after %d failures", cb.failures)
+        }
+        cb.state = CircuitOpen
+        cb.openedAt = time.Now()
+    }
+}
+
+// Release gives back a half-open probe whose call was cancelled, so it
+// neither closes nor reopens the breaker
+func (cb *CircuitBreaker) Release() {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    cb.probing = false
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    return cb.state
+}
+
+// ResilientBackend retries transient failures with jittered backoff and
+// moves on to a fallback backend when the primary's breaker is open.
+type ResilientBackend struct {
+    primary         ChatBackend
+    fallback        ChatBackend
+    primaryBreaker  *CircuitBreaker
+    fallbackBreaker *CircuitBreaker
+    retry           RetryPolicy
+    rng             *rand.Rand
+    rngMu           sync.Mutex
+}
+
+func NewResilientBackend(primary ChatBackend, fallback ChatBackend, config ResilienceConfig) *ResilientBackend {
+    if config.Retry.MaxAttempts <= 0 {
+        config.Retry.MaxAttempts = 3
+    }
+    if config.Retry.BaseDelay <= 0 {
+        config.Retry.BaseDelay = 250 * time.Millisecond
+    }
+    if config.Retry.MaxDelay <= 0 {
+        config.Retry.MaxDelay = 4 * time.Second
+    }
+
+    rb := &ResilientBackend{
+        primary:        primary,
+        fallback:       fallback,
+        primaryBreaker: NewCircuitBreaker(config.Breaker),
+        retry:          config.Retry,
+        rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
+    }
+    if fallback != nil {
+        rb.fallbackBreaker = NewCircuitBreaker(config.Breaker)
+    }
+
+    return rb
+}
+
+// NewFallbackBackend builds the fallback from config. A bare model name
+// reuses the primary backend with that model.
+func NewFallbackBackend(config BackendConfig, primary ChatBackend, openAIKey string) (ChatBackend, error) {
+    if config.Type == "" && config.Model == "" {
+        return nil, nil
+    }
+    if config.Type == "" {
+        return &modelOverrideBackend{ChatBackend: primary, model: config.Model}, nil
+    }
+    return NewChatBackend(config, openAIKey)
+}
+
+type modelOverrideBackend struct {
+    ChatBackend
+    model string
+}
+
+func (b *modelOverrideBackend) Name() string {
+    return b.ChatBackend.Name() + ":" + b.model
+}
+
+func (b *modelOverrideBackend) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+    req.Model = b.model
+    return b.ChatBackend.CreateChatCompletion(ctx, req)
+}
+
+func (b *modelOverrideBackend) CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error) {
+    req.Model = b.model
+    return b.ChatBackend.CreateChatCompletionStream(ctx, req)
+}
+
+func (rb *ResilientBackend) Name() string {
+    if rb.primaryBreaker.State() == CircuitOpen && rb.fallback != nil {
+        return rb.fallback.Name()
+    }
+    return rb.primary.Name()
+}
+
+func (rb *ResilientBackend) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+    var resp *ChatResponse
+    err := rb.do(ctx, func(backend ChatBackend) error {
+        var err error
+        resp, err = backend.CreateChatCompletion(ctx, req)
+        return err
+    })
+    return resp, err
+}
+
+// Only opening the stream is retried; a stream that breaks halfway has
+// already been partly spoken.
+func (rb *ResilientBackend) CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error) {
+    var stream ChatStream
+    err := rb.do(ctx, func(backend ChatBackend) error {
+        var err error
+        stream, err = backend.CreateChatCompletionStream(ctx, req)
+        return err
+    })
+    return stream, err
+}
+
+func (rb *ResilientBackend) do(ctx context.Context, call func(ChatBackend) error) error {
+    lastErr := rb.attempt(ctx, rb.primary, rb.primaryBreaker, call)
+    if lastErr == nil {
+        return nil
+    }
+    if ctx.Err() != nil {
+        return ctx.Err()
+    }
+
+    if rb.fallback != nil {
+        log.Printf("Primary LLM backend failed, trying %s: %v", rb.fallback.Name(), lastErr)
+        err := rb.attempt(ctx, rb.fallback, rb.fallbackBreaker, call)
+        if err == nil {
+            return nil
+        }
+        lastErr = err
+    }
+
+    return fmt.Errorf("%w: %v", ErrBackendUnavailable, lastErr)
+}
+
+// attempt calls backend with retries. The breaker sees the outcome once per
+// call, not once per retry, so FailureThreshold counts failed calls.
+func (rb *ResilientBackend) attempt(ctx context.Context, backend ChatBackend, breaker *CircuitBreaker, call func(ChatBackend) error) error {
+    if !breaker.Allow() {
+        return fmt.Errorf("%s circuit breaker open", backend.Name())
+    }
+
+    var err error
+    for attempt := 0; attempt < rb.retry.MaxAttempts; attempt++ {
+        if attempt > 0 {
+            select {
+            case <-time.After(rb.backoff(attempt - 1)):
+            case <-ctx.Done():
+                breaker.Release()
+                return ctx.Err()
+            }
+        }
+
+        err = call(backend)
+        if err == nil || ctx.Err() != nil || !isTransientError(err) {
+            break
+        }
+    }
+
+    switch {
+    case ctx.Err() != nil && err != nil:
+        // A cancelled request says nothing about the backend's health
+        breaker.Release()
+    case err == nil || !isTransientError(err):
+        // The backend answered, even if it rejected the request
+        breaker.RecordSuccess()
+    default:
+        breaker.RecordFailure()
+    }
+    return err
+}
+
+// backoff uses full jitter: a random delay up to the exponential cap
+func (rb *ResilientBackend) backoff(attempt int) time.Duration {
+    ceiling := rb.retry.BaseDelay << uint(attempt)
+    if ceiling <= 0 || ceiling > rb.retry.MaxDelay {
+        ceiling = rb.retry.MaxDelay
+    }
+
+    rb.rngMu.Lock()
+    defer rb.rngMu.Unlock()
+    return time.Duration(rb.rng.Int63n(int64(ceiling) + 1))
+}
+
+func isTransientError(err error) bool {
+    if errors.Is(err, context.Canceled) {
+        return false
+    }
+
+    status := 0
+    var apiErr *openai.APIError
+    var reqErr *openai.RequestError
+    switch {
+    case errors.As(err, &apiErr):
+        status = apiErr.HTTPStatusCode
+    case errors.As(err, &reqErr):
+        status = reqErr.HTTPStatusCode
+    default:
+        // Network errors, timeouts and unknown failures are worth a retry
+        return true
+    }
+
+    return status == http.StatusRequestTimeout ||
+        status == http.StatusTooManyRequests ||
+        status >= http.StatusInternalServerError
+}
+
+// Health reports breaker state for the stream health check
+func (rb *ResilientBackend) Health() HealthStatus {
+    primary := rb.primaryBreaker.State()
+    if primary == CircuitClosed {
+        return HealthStatus{Healthy: true, Detail: "primary " + string(primary)}
+    }
+
+    if rb.fallbackBreaker != nil {
+        fallback := rb.fallbackBreaker.State()
+        return HealthStatus{
+            Healthy:  false,
+            Degraded: fallback == CircuitClosed,
+            Detail:   fmt.Sprintf("primary %s, fallback %s", primary, fallback),
+        }
+    }
+    return HealthStatus{Healthy: false, Detail: "primary " + string(primary)}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/sashabaranov/go-openai"
+)
+
+type fakeBackend struct {
+    errs  []error // returned in order, then nil
+    calls int
+}
+
+func (b *fakeBackend) Name() string {
+    return "fake"
+}
+
+func (b *fakeBackend) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+    b.calls++
+    if len(b.errs) > 0 {
+        err := b.errs[0]
+        b.errs = b.errs[1:]
+        if err != nil {
+            return nil, err
+        }
+    }
+    return &ChatResponse{Content: "ok"}, nil
+}
+
+func (b *fakeBackend) CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error) {
+    return nil, errors.New("not implemented")
+}
+
+func TestCircuitBreaker(t *testing.T) {
+    tests := []struct {
+        name   string
+        events string // f = failure, s = success, w = wait out OpenDuration
+        want   CircuitState
+        allow  bool
+    }{
+        {name: "fresh", events: "", want: CircuitClosed, allow: true},
+        {name: "below threshold", events: "ff", want: CircuitClosed, allow: true},
+        {name: "trips at threshold", events: "fff", want: CircuitOpen, allow: false},
+        {name: "success resets count", events: "ffsff", want: CircuitClosed, allow: true},
+        {name: "probes after open duration", events: "fffw", want: CircuitHalfOpen, allow: true},
+        {name: "failed probe reopens", events: "fffwf", want: CircuitOpen, allow: false},
+        {name: "good probe closes", events: "fffws", want: CircuitClosed, allow: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: 10 * time.Millisecond})
+            for _, event := range tt.events {
+                switch event {
+                case 'f':
+                    cb.RecordFailure()
+                case 's':
+                    cb.RecordSuccess()
+                case 'w':
+                    time.Sleep(15 * time.Millisecond)
+                    if !cb.Allow() {
+                        t.Fatal("breaker didn't let a probe through")
+                    }
+                }
+            }
+            if got := cb.State(); got != tt.want {
+                t.Errorf("state = %s, want %s", got, tt.want)
+            }
+            // A half-open breaker lets only its one probe through
+            if tt.want == CircuitHalfOpen {
+                tt.allow = false
+            }
+            if got := cb.Allow(); got != tt.allow {
+                t.Errorf("Allow() = %v, want %v", got, tt.allow)
+            }
+        })
+    }
+}
+
+func TestResilientBackendCountsFailedCalls(t *testing.T) {
+    transient := errors.New("connection reset")
+    rejected := &openai.APIError{HTTPStatusCode: 400, Message: "bad request"}
+
+    tests := []struct {
+        name      string
+        errs      []error
+        wantCalls int
+        wantErr   bool
+        want      CircuitState
+    }{
+        {name: "retried into success", errs: []error{transient, transient}, wantCalls: 3, want: CircuitClosed},
+        {name: "every retry transient", errs: []error{transient, transient, transient}, wantCalls: 3, wantErr: true, want: CircuitClosed},
+        {name: "rejected request", errs: []error{rejected}, wantCalls: 1, wantErr: true, want: CircuitClosed},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            backend := &fakeBackend{errs: tt.errs}
+            rb := NewResilientBackend(backend, nil, ResilienceConfig{
+                Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond},
+                Breaker: CircuitBreakerConfig{FailureThreshold: 2},
+            })
+
+            _, err := rb.CreateChatCompletion(context.Background(), ChatRequest{})
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+            }
+            if backend.calls != tt.wantCalls {
+                t.Errorf("calls = %d, want %d", backend.calls, tt.wantCalls)
+            }
+            if got := rb.primaryBreaker.State(); got != tt.want {
+                t.Errorf("breaker = %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestResilientBackendTripsAfterFailedCalls(t *testing.T) {
+    transient := errors.New("connection reset")
+    backend := &fakeBackend{errs: []error{transient, transient, transient, transient, transient, transient}}
+    fallback := &fakeBackend{}
+    rb := NewResilientBackend(backend, fallback, ResilienceConfig{
+        Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond},
+        Breaker: CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Hour},
+    })
+
+    for call := 1; call <= 3; call++ {
+        if _, err := rb.CreateChatCompletion(context.Background(), ChatRequest{}); err != nil {
+            t.Fatalf("call %d: %v", call, err)
+        }
+    }
+
+    // Two failed calls of three attempts each trip the breaker, the third
+    // call goes straight to the fallback
+    if backend.calls != 6 {
+        t.Errorf("primary calls = %d, want 6", backend.calls)
+    }
+    if fallback.calls != 3 {
+        t.Errorf("fallback calls = %d, want 3", fallback.calls)
+    }
+    if got := rb.primaryBreaker.State(); got != CircuitOpen {
+        t.Errorf("breaker = %s, want open", got)
+    }
+}
+
+func TestResilientBackendIgnoresCancelledCalls(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    backend := &fakeBackend{errs: []error{context.Canceled}}
+    rb := NewResilientBackend(backend, nil, ResilienceConfig{
+        Breaker: CircuitBreakerConfig{FailureThreshold: 1},
+    })
+    if _, err := rb.CreateChatCompletion(ctx, ChatRequest{}); err == nil {
+        t.Fatal("cancelled call succeeded")
+    }
+    if got := rb.primaryBreaker.State(); got != CircuitClosed {
+        t.Errorf("breaker = %s, want closed", got)
+    }
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "hash/fnv"
+    "log"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+)
+
+type ResponseCacheConfig struct {
+    Enabled    bool
+    Threshold  float64       // minimum cosine similarity for a hit
+    TTL        time.Duration
+    MaxEntries int
+    Rephrase   bool          // ask for a light rewording instead of repeating verbatim
+    Embedder   EmbedderConfig
+}
+
+type cacheEntry struct {
+    normalized string
+    embedding  []float64
+    scope      string
+    text       string
+    emotion    string
+    createdAt  time.Time
+    hits       int
+}
+
+// CacheHit is a cached answer close enough to reuse
+type CacheHit struct {
+    Text       string
+    Emotion    string
+    Similarity float64
+}
+
+// ResponseCache answers repeated chat questions without a full completion.
+// Entries are scoped so an answer is only reused under the same persona,
+// mood and reply language it was given in.
+type ResponseCache struct {
+    config   ResponseCacheConfig
+    embedder Embedder
+    mu       sync.Mutex
+
+    entries []*cacheEntry
+}
+
+func NewResponseCache(config ResponseCacheConfig, embedder Embedder) *ResponseCache {
+    if config.Threshold <= 0 {
+        config.Threshold = 0.9
+    }
+    if config.TTL <= 0 {
+        config.TTL = 10 * time.Minute
+    }
+    if config.MaxEntries <= 0 {
+        config.MaxEntries = 500
+    }
+    if embedder == nil {
+        embedder = NewHashingEmbedder(0)
+    }
+
+    return &ResponseCache{config: config, embedder: embedder}
+}
+
+// SetEmbedder switches the embedder and drops the cached entries, whose
+// vectors can't be compared with the new ones. Lookup and Store don't lock
+// around the embedder, so call it before the cache is in use.
+func (c *ResponseCache) SetEmbedder(embedder Embedder) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.embedder = embedder
+    c.entries = nil
+}
+
+// addressesViewer reports whether reply mentions any of names. It errs on
+// the side of a cache miss, so short names inside other words also count.
+func addressesViewer(reply string, names []string) bool {
+    reply = strings.ToLower(reply)
+    for _, name := range names {
+        name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "@")))
+        if name != "" && strings.Contains(reply, name) {
+            return true
+        }
+    }
+    return false
+}
+
+// cacheScope keys entries on everything that should change the answer
+func cacheScope(personaPrompt string, emotion string, language string) string {
+    h := fnv.New64a()
+    h.Write([]byte(personaPrompt))
+    return fmt.Sprintf("%x/%s/%s", h.Sum64(), emotion, language)
+}
+
+func (c *ResponseCache) Lookup(ctx context.Context, input string, scope string) (CacheHit, bool) {
+    normalized := normalizeChatInput(input)
+    if normalized == "" {
+        return CacheHit{}, false
+    }
+
+    embedding, err := c.embedder.Embed(ctx, normalized)
+    if err != nil {
+        log.Printf("Response cache embedding failed: %v", err)
+        return CacheHit{}, false
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.expire(time.Now())
+
+    var best *cacheEntry
+    bestSimilarity := 0.0
+    for _, entry := range c.entries {
+        if entry.scope != scope {
+            continue
+        }
+
+        similarity := 1.0
+        if entry.normalized != normalized {
+            similarity = cosineSimilarity(embedding, entry.embedding)
+        }
+        if similarity >= c.config.Threshold && similarity > bestSimilarity {
+            best, bestSimilarity = entry, similarity
+        }
+    }
+    if best == nil {
+        return CacheHit{}, false
+    }
+
+    best.hits++
+    return CacheHit{Text: best.text, Emotion: best.emotion, Similarity: bestSimilarity}, true
+}
+
+func (c *ResponseCache) Store(ctx context.Context, input string, scope string, text string, emotion string) {
+    normalized := normalizeChatInput(input)
+    if normalized == "" || text == "" {
+        return
+    }
+
+    embedding, err := c.embedder.Embed(ctx, normalized)
+    if err != nil {
+        log.Printf("Response cache embedding failed: %v", err)
+        return
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.expire(time.Now())
+    if len(c.entries) >= c.config.MaxEntries {
+        c.entries = c.entries[1:]
+    }
+    c.entries = append(c.entries, &cacheEntry{
+        normalized: normalized,
+        embedding:  embedding,
+        scope:      scope,
+        text:       text,
+        emotion:    emotion,
+        createdAt:  time.Now(),
+    })
+}
+
+// Entries are kept oldest first
+func (c *ResponseCache) expire(now time.Time) {
+    drop := 0
+    for drop < len(c.entries) && now.Sub(c.entries[drop].createdAt) > c.config.TTL {
+        drop++
+    }
+    c.entries = c.entries[drop:]
+}
+
+func (c *ResponseCache) Len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return len(c.entries)
+}
+
+// normalizeChatInput folds the ways chat types the same question: case,
+// punctuation, emotes and stretched letters ("whennn mooooon??").
+func normalizeChatInput(text string) string {
+    var b strings.Builder
+    var last rune
+    repeats := 0
+    for _, r := range strings.ToLower(text) {
+        if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+            r = ' '
+        }
+        if r == last {
+            repeats++
+            if repeats >= 2 {
+                continue
+            }
+        } else {
+            repeats = 0
+        }
+        last = r
+        b.WriteRune(r)
+    }
+    return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "log"
+    "math"
+    "math/rand"
+    "sync"
+    "time"
+)
+
+// Added on top of the base parameters for the emotion being answered
+var defaultEmotionModifiers = map[string]SamplingParams{
+    "excited":   {Temperature: 0.15, PresencePenalty: 0.1},
+    "happy":     {Temperature: 0.05},
+    "surprised": {Temperature: 0.1},
+    "sad":       {Temperature: -0.1, TopP: -0.05},
+    "angry":     {Temperature: -0.15, TopP: -0.1},
+    "fearful":   {Temperature: -0.1},
+}
+
+type SamplingParams struct {
+    Temperature      float64 `json:"temperature"`
+    TopP             float64 `json:"top_p"`
+    PresencePenalty  float64 `json:"presence_penalty"`
+    FrequencyPenalty float64 `json:"frequency_penalty"`
+}
+
+// SamplingBase is the base parameters, or a bound, as configured. Nil fields
+// take the defaults, so 0 and negative penalties can be set explicitly.
+type SamplingBase struct {
+    Temperature      *float64 `json:"temperature"`
+    TopP             *float64 `json:"top_p"`
+    PresencePenalty  *float64 `json:"presence_penalty"`
+    FrequencyPenalty *float64 `json:"frequency_penalty"`
+}
+
+type SamplingConfig struct {
+    Seed             int64 // 0 picks one from the clock, which is logged
+    Base             SamplingBase
+    EmotionModifiers map[string]SamplingParams
+    ConfidenceWeight *float64 // extra temperature for inputs we're unsure about, nil for 0.2
+    Jitter           *float64 // max random temperature offset either way, nil for 0.05
+    Min              SamplingBase
+    Max              SamplingBase
+}
+
+// SamplingPolicy picks the sampling parameters for each reply. All the
+// randomness comes from a seeded source so a run can be reproduced.
+type SamplingPolicy struct {
+    config           SamplingConfig
+    base             SamplingParams
+    min              SamplingParams
+    max              SamplingParams
+    confidenceWeight float64
+    jitter           float64
+    seed             int64
+    rng              *rand.Rand
+    mu               sync.Mutex
+}
+
+func NewSamplingPolicy(config SamplingConfig, baseTemperature float64) *SamplingPolicy {
+    if baseTemperature == 0 {
+        baseTemperature = 0.8
+    }
+    base := config.Base.resolve(SamplingParams{Temperature: baseTemperature, TopP: 0.9, PresencePenalty: 0.6, FrequencyPenalty: 0.3})
+    if config.EmotionModifiers == nil {
+        config.EmotionModifiers = defaultEmotionModifiers
+    }
+    // Modifiers may be keyed by alias
+    modifiers := make(map[string]SamplingParams, len(config.EmotionModifiers))
+    for label, mod := range config.EmotionModifiers {
+        modifiers[emotionTaxonomy().Canonical(label)] = mod
+    }
+    config.EmotionModifiers = modifiers
+
+    seed := config.Seed
+    if seed == 0 {
+        seed = time.Now().UnixNano()
+    }
+    log.Printf("Sampling policy seed: %d", seed)
+
+    return &SamplingPolicy{
+        config:           config,
+        base:             base,
+        min:              config.Min.resolve(SamplingParams{Temperature: 0.1, TopP: 0.1, PresencePenalty: -2, FrequencyPenalty: -2}),
+        max:              config.Max.resolve(SamplingParams{Temperature: 1.5, TopP: 1, PresencePenalty: 2, FrequencyPenalty: 2}),
+        confidenceWeight: orDefault(config.ConfidenceWeight, 0.2),
+        jitter:           orDefault(config.Jitter, 0.05),
+        seed:             seed,
+        rng:              rand.New(rand.NewSource(seed)),
+    }
+}
+
+func (b SamplingBase) resolve(defaults SamplingParams) SamplingParams {
+    return SamplingParams{
+        Temperature:      orDefault(b.Temperature, defaults.Temperature),
+        TopP:             orDefault(b.TopP, defaults.TopP),
+        PresencePenalty:  orDefault(b.PresencePenalty, defaults.PresencePenalty),
+        FrequencyPenalty: orDefault(b.FrequencyPenalty, defaults.FrequencyPenalty),
+    }
+}
+
+func orDefault(value *float64, fallback float64) float64 {
+    if value == nil {
+        return fallback
+    }
+    return *value
+}
+
+func (p *SamplingPolicy) Seed() int64 {
+    return p.seed
+}
+
+// Sample returns bounded parameters for a reply to input with the given
+// emotion. moodModifier is the emotion engine's temperature adjustment.
+func (p *SamplingPolicy) Sample(emotion string, confidence float64, moodModifier float64) SamplingParams {
+    p.mu.Lock()
+    jitter := (p.rng.Float64()*2 - 1) * p.jitter
+    p.mu.Unlock()
+
+    params := p.base
+    if mod, ok := p.config.EmotionModifiers[emotion]; ok {
+        params.Temperature += mod.Temperature
+        params.TopP += mod.TopP
+        params.PresencePenalty += mod.PresencePenalty
+        params.FrequencyPenalty += mod.FrequencyPenalty
+    }
+    params.Temperature += moodModifier + p.confidenceWeight*(1-confidence) + jitter
+
+    return p.clamp(params)
+}
+
+// Configured bounds can only narrow what the API accepts
+func (p *SamplingPolicy) clamp(params SamplingParams) SamplingParams {
+    min, max := p.min, p.max
+    return SamplingParams{
+        Temperature:      clampRange(params.Temperature, math.Max(min.Temperature, 0), math.Min(max.Temperature, 2)),
+        TopP:             clampRange(params.TopP, math.Max(min.TopP, 0), math.Min(max.TopP, 1)),
+        PresencePenalty:  clampRange(params.PresencePenalty, math.Max(min.PresencePenalty, -2), math.Min(max.PresencePenalty, 2)),
+        FrequencyPenalty: clampRange(params.FrequencyPenalty, math.Max(min.FrequencyPenalty, -2), math.Min(max.FrequencyPenalty, 2)),
+    }
+}
+
+func clampRange(value float64, min float64, max float64) float64 {
+    return math.Max(min, math.Min(max, value))
+}
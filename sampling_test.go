@@ -0,0 +1,127 @@
+package main
+
+import (
+    "math"
+    "testing"
+)
+
+func float(value float64) *float64 {
+    return &value
+}
+
+func TestSamplingPolicyBase(t *testing.T) {
+    tests := []struct {
+        name   string
+        config SamplingConfig
+        want   SamplingParams
+    }{
+        {
+            name:   "defaults",
+            config: SamplingConfig{Jitter: float(0), ConfidenceWeight: float(0)},
+            want:   SamplingParams{Temperature: 0.7, TopP: 0.9, PresencePenalty: 0.6, FrequencyPenalty: 0.3},
+        },
+        {
+            name: "zero penalties",
+            config: SamplingConfig{
+                Base:             SamplingBase{PresencePenalty: float(0), FrequencyPenalty: float(0)},
+                Jitter:           float(0),
+                ConfidenceWeight: float(0),
+            },
+            want: SamplingParams{Temperature: 0.7, TopP: 0.9},
+        },
+        {
+            name: "negative penalties",
+            config: SamplingConfig{
+                Base:             SamplingBase{Temperature: float(1), PresencePenalty: float(-0.5), FrequencyPenalty: float(-1)},
+                Jitter:           float(0),
+                ConfidenceWeight: float(0),
+            },
+            want: SamplingParams{Temperature: 1, TopP: 0.9, PresencePenalty: -0.5, FrequencyPenalty: -1},
+        },
+        {
+            name: "uncertain input runs hotter",
+            config: SamplingConfig{
+                Jitter:           float(0),
+                ConfidenceWeight: float(0.4),
+            },
+            want: SamplingParams{Temperature: 0.9, TopP: 0.9, PresencePenalty: 0.6, FrequencyPenalty: 0.3},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.config.Seed = 1
+            tt.config.EmotionModifiers = map[string]SamplingParams{}
+            p := NewSamplingPolicy(tt.config, 0.7)
+
+            got := p.Sample("neutral", 0.5, 0)
+            if math.Abs(got.Temperature-tt.want.Temperature) > 1e-9 ||
+                math.Abs(got.TopP-tt.want.TopP) > 1e-9 ||
+                math.Abs(got.PresencePenalty-tt.want.PresencePenalty) > 1e-9 ||
+                math.Abs(got.FrequencyPenalty-tt.want.FrequencyPenalty) > 1e-9 {
+                t.Errorf("Sample = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestSamplingPolicyIsReproducible(t *testing.T) {
+    config := SamplingConfig{Seed: 42, Jitter: float(0.2)}
+    a := NewSamplingPolicy(config, 0.8)
+    b := NewSamplingPolicy(config, 0.8)
+
+    for i := 0; i < 5; i++ {
+        if x, y := a.Sample("happy", 0.8, 0), b.Sample("happy", 0.8, 0); x != y {
+            t.Fatalf("sample %d differs: %+v vs %+v", i, x, y)
+        }
+    }
+}
+
+func TestSamplingPolicyClamps(t *testing.T) {
+    tests := []struct {
+        name   string
+        config SamplingConfig
+        want   SamplingParams
+    }{
+        {
+            name: "max",
+            config: SamplingConfig{
+                Base: SamplingBase{Temperature: float(1.9), PresencePenalty: float(3), FrequencyPenalty: float(3)},
+                Max:  SamplingBase{Temperature: float(1.2), PresencePenalty: float(1)},
+            },
+            // Frequency penalty keeps the default max of 2
+            want: SamplingParams{Temperature: 1.2, TopP: 0.9, PresencePenalty: 1, FrequencyPenalty: 2},
+        },
+        {
+            name: "min",
+            config: SamplingConfig{
+                Base: SamplingBase{Temperature: float(0), TopP: float(0), PresencePenalty: float(-3)},
+                Min:  SamplingBase{Temperature: float(0.5), PresencePenalty: float(0)},
+            },
+            // Top p keeps the default min of 0.1
+            want: SamplingParams{Temperature: 0.5, TopP: 0.1, PresencePenalty: 0, FrequencyPenalty: 0.3},
+        },
+        {
+            name: "explicit zero min",
+            config: SamplingConfig{
+                Base: SamplingBase{Temperature: float(0), TopP: float(0)},
+                Min:  SamplingBase{Temperature: float(0), TopP: float(0)},
+            },
+            want: SamplingParams{Temperature: 0, TopP: 0, PresencePenalty: 0.6, FrequencyPenalty: 0.3},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.config.Seed = 1
+            tt.config.Jitter = float(0)
+            tt.config.ConfidenceWeight = float(0)
+            tt.config.EmotionModifiers = map[string]SamplingParams{}
+            p := NewSamplingPolicy(tt.config, 0.7)
+
+            if got := p.Sample("neutral", 1, 0); got != tt.want {
+                t.Errorf("Sample = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
@@ -147,6 +147,22 @@ func (tp *TipProcessor) processTips(ctx context.Context) {
     }
 }
 
+// RecentTips returns up to limit tips, newest first
+func (tp *TipProcessor) RecentTips(limit int) []TipEvent {
+    tp.mu.RLock()
+    defer tp.mu.RUnlock()
+
+    if limit > len(tp.tipHistory) {
+        limit = len(tp.tipHistory)
+    }
+
+    tips := make([]TipEvent, 0, limit)
+    for i := len(tp.tipHistory) - 1; i >= len(tp.tipHistory)-limit; i-- {
+        tips = append(tips, tp.tipHistory[i])
+    }
+    return tips
+}
+
 func (tp *TipProcessor) triggerRewards(tip TipEvent) {
     reward := tp.getReward(tip.RewardTier)
     // Implement reward triggering logic here
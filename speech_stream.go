@@ -0,0 +1,161 @@
+package main
+
+import (
+    "context"
+    "log"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+const defaultMinSentenceRunes = 12
+
+type SpeechChunk struct {
+    Index     int
+    Text      string
+    Emotion   string
+    Intensity float64
+    Language  string
+
+    // Emotion mixed in, and its share, so the avatar and voice blend
+    Secondary string
+    Blend     float64
+}
+
+// SentenceChunker turns a token stream into sentence-sized pieces of text
+// that can be handed to TTS while the rest of the reply is still generating.
+type SentenceChunker struct {
+    buffer   strings.Builder
+    minRunes int
+}
+
+func NewSentenceChunker(minRunes int) *SentenceChunker {
+    if minRunes <= 0 {
+        minRunes = defaultMinSentenceRunes
+    }
+    return &SentenceChunker{minRunes: minRunes}
+}
+
+// Write appends a token and returns any sentences it completed
+func (c *SentenceChunker) Write(token string) []string {
+    c.buffer.WriteString(token)
+
+    var sentences []string
+    for {
+        text := c.buffer.String()
+        end := findSentenceEnd(text, c.minRunes)
+        if end < 0 {
+            break
+        }
+
+        if sentence := strings.TrimSpace(text[:end]); sentence != "" {
+            sentences = append(sentences, sentence)
+        }
+        c.buffer.Reset()
+        c.buffer.WriteString(text[end:])
+    }
+
+    return sentences
+}
+
+// Flush returns whatever is left once the stream is finished
+func (c *SentenceChunker) Flush() string {
+    rest := strings.TrimSpace(c.buffer.String())
+    c.buffer.Reset()
+    return rest
+}
+
+// findSentenceEnd returns the byte offset just past the first sentence
+// boundary that leaves at least minRunes of text, or -1 if the buffer does
+// not hold a complete sentence yet.
+func findSentenceEnd(text string, minRunes int) int {
+    runes := 0
+    for i, r := range text {
+        runes++
+        if r == '\n' && runes >= minRunes {
+            return i + 1
+        }
+        if !isSentenceTerminal(r) {
+            continue
+        }
+
+        // Swallow trailing punctuation and closing quotes ("Really?!" etc.)
+        end := i + utf8.RuneLen(r)
+        for end < len(text) {
+            next, size := utf8.DecodeRuneInString(text[end:])
+            if !isSentenceTerminal(next) && !isClosingMark(next) {
+                break
+            }
+            end += size
+        }
+
+        if runes < minRunes {
+            continue
+        }
+        // CJK full stops need no following space
+        if isFullWidthTerminal(r) {
+            return end
+        }
+        // Wait for the next token so "3.14" is not split early
+        if end >= len(text) {
+            return -1
+        }
+        if next, _ := utf8.DecodeRuneInString(text[end:]); unicode.IsSpace(next) {
+            return end
+        }
+    }
+    return -1
+}
+
+func isSentenceTerminal(r rune) bool {
+    switch r {
+    case '.', '!', '?', '…':
+        return true
+    }
+    return isFullWidthTerminal(r)
+}
+
+func isFullWidthTerminal(r rune) bool {
+    switch r {
+    case '。', '！', '？':
+        return true
+    }
+    return false
+}
+
+func isClosingMark(r rune) bool {
+    switch r {
+    case '"', '\'', ')', ']', '」', '』', '”', '’':
+        return true
+    }
+    return false
+}
+
+// Speak voices a whole reply. In structured mode the avatar takes the
+// model's own intensity and plays the first of its gestures it has.
+func Speak(ctx context.Context, response *Response, voice *VoiceSynthesizer, avatar *AvatarRenderer) error {
+    if response.Intensity > 0 {
+        avatar.Update(response.Emotion, response.Intensity)
+    }
+    for _, gesture := range response.Gestures {
+        if avatar.PlayGesture(gesture) {
+            break
+        }
+    }
+
+    _, err := voice.SynthesizeLang(ctx, response.Text, response.Emotion, response.Language)
+    return err
+}
+
+// SpeakStream voices chunks as they arrive so the avatar starts talking on
+// the first sentence. It returns once the chunk channel is closed.
+func SpeakStream(ctx context.Context, chunks <-chan SpeechChunk, voice *VoiceSynthesizer, avatar *AvatarRenderer) {
+    for chunk := range chunks {
+        avatar.UpdateBlend(chunk.Emotion, chunk.Secondary, chunk.Blend, chunk.Intensity)
+
+        // A failed sentence should not silence the rest of the reply
+        if _, err := voice.SynthesizeBlend(ctx, chunk.Text, chunk.Emotion, chunk.Secondary, chunk.Blend, chunk.Language); err != nil {
+            log.Printf("Speech synthesis failed for chunk %d: %v", chunk.Index, err)
+        }
+    }
+}
@@ -3,6 +3,7 @@ package main
 import (
     "context"
     "fmt"
+    "log"
     "sync"
     "time"
 
@@ -23,6 +24,11 @@ type StreamManager struct {
     startTime      time.Time
     viewers        int
     frameBuffer    *FrameBuffer
+
+    // Component health
+    healthChecks   map[string]HealthCheck
+    health         map[string]HealthStatus
+    stopHooks      []func()
 }
 
 type StreamConfig struct {
@@ -48,6 +54,14 @@ type StreamStats struct {
     Health         float64
 }
 
+type HealthStatus struct {
+    Healthy  bool
+    Degraded bool
+    Detail   string
+}
+
+type HealthCheck func() HealthStatus
+
 type Resolution struct {
     Width  int
     Height int
@@ -58,6 +72,8 @@ func NewStreamManager(config StreamConfig) (*StreamManager, error) {
         config:      config,
         frameBuffer: NewFrameBuffer(config.FrameRate),
         stats:       &StreamStats{},
+        healthChecks: make(map[string]HealthCheck),
+        health:      make(map[string]HealthStatus),
     }
 
     // Initialize processors
@@ -126,9 +142,9 @@ func (sm *StreamManager) StartStream(ctx context.Context) error {
 
 func (sm *StreamManager) StopStream() error {
     sm.mu.Lock()
-    defer sm.mu.Unlock()
 
     if !sm.isLive {
+        sm.mu.Unlock()
         return nil
     }
 
@@ -139,9 +155,35 @@ func (sm *StreamManager) StopStream() error {
     sm.videoProcessor.Stop()
     sm.rtmpClient.Disconnect()
 
+    hooks := sm.stopHooks
+    sm.mu.Unlock()
+
+    // Hooks may query the manager, so run them unlocked
+    for _, hook := range hooks {
+        hook()
+    }
+
     return nil
 }
 
+// OnStop registers a function to run once the stream has ended
+func (sm *StreamManager) OnStop(hook func()) {
+    sm.mu.Lock()
+    defer sm.mu.Unlock()
+
+    sm.stopHooks = append(sm.stopHooks, hook)
+}
+
+// Stats returns a snapshot of the stream statistics and whether we are live
+func (sm *StreamManager) Stats() (StreamStats, bool) {
+    sm.mu.RLock()
+    defer sm.mu.RUnlock()
+
+    stats := *sm.stats
+    stats.ViewerCount = sm.viewers
+    return stats, sm.isLive
+}
+
 func (sm *StreamManager) streamLoop(ctx context.Context) {
     ticker := time.NewTicker(time.Second / time.Duration(sm.config.FrameRate))
     defer ticker.Stop()
@@ -209,9 +251,64 @@ func (sm *StreamManager) monitorStream(ctx context.Context) {
     }
 }
 
+// RegisterHealthCheck adds a component (LLM backend, TTS, ...) to the
+// periodic stream health check
+func (sm *StreamManager) RegisterHealthCheck(name string, check HealthCheck) {
+    sm.mu.Lock()
+    defer sm.mu.Unlock()
+
+    sm.healthChecks[name] = check
+}
+
+// HealthReport returns the latest status of every registered component
+func (sm *StreamManager) HealthReport() map[string]HealthStatus {
+    sm.mu.RLock()
+    defer sm.mu.RUnlock()
+
+    report := make(map[string]HealthStatus, len(sm.health))
+    for name, status := range sm.health {
+        report[name] = status
+    }
+    return report
+}
+
 func (sm *StreamManager) checkStreamHealth() {
+    // Checks may be slow or call back into the manager, so they run
+    // without the lock
+    sm.mu.Lock()
+    checks := make(map[string]HealthCheck, len(sm.healthChecks))
+    for name, check := range sm.healthChecks {
+        checks[name] = check
+    }
+    sm.mu.Unlock()
+
+    statuses := make(map[string]HealthStatus, len(checks))
+    for name, check := range checks {
+        statuses[name] = check()
+    }
+
     sm.mu.Lock()
     defer sm.mu.Unlock()
 
-    //
+    if len(statuses) == 0 {
+        sm.stats.Health = 1.0
+        return
+    }
+
+    // Healthy components score 1, degraded ones 0.5
+    score := 0.0
+    for name, status := range statuses {
+        if previous, ok := sm.health[name]; ok && previous.Healthy != status.Healthy {
+            log.Printf("Health of %s changed: %s", name, status.Detail)
+        }
+        sm.health[name] = status
+
+        switch {
+        case status.Healthy:
+            score += 1.0
+        case status.Degraded:
+            score += 0.5
+        }
+    }
+    sm.stats.Health = score / float64(len(statuses))
 } 
\ No newline at end of file
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+var defaultResponseActions = []string{"thank_tipper", "start_poll", "shoutout"}
+
+type StructuredOutputConfig struct {
+    Enabled  bool
+    Gestures []string // animation names the avatar can play
+    Actions  []string // action types the stream can carry out
+}
+
+// StructuredReply is the JSON object the model returns in structured mode
+type StructuredReply struct {
+    Text     string           `json:"text"`
+    Emotion  ReplyEmotion     `json:"emotion"`
+    Gestures []string         `json:"gestures"`
+    Actions  []ResponseAction `json:"actions"`
+}
+
+type ReplyEmotion struct {
+    Label     string  `json:"label"`
+    Intensity float64 `json:"intensity"`
+}
+
+type ResponseAction struct {
+    Type   string            `json:"type"`
+    Target string            `json:"target,omitempty"`
+    Params map[string]string `json:"params,omitempty"`
+}
+
+func structuredOutputPrompt(config StructuredOutputConfig) string {
+    actions := config.Actions
+    if len(actions) == 0 {
+        actions = defaultResponseActions
+    }
+
+    gestures := "none available"
+    if len(config.Gestures) > 0 {
+        gestures = strings.Join(config.Gestures, ", ")
+    }
+
+    return fmt.Sprintf(`Reply with a single JSON object and nothing else:
+{"text": string, "emotion": {"label": string, "intensity": number 0-1}, "gestures": [string], "actions": [{"type": string, "target": string, "params": {string: string}}]}
+"text" is exactly what you say out loud. Gestures are optional, one of: %s.
+Actions are optional, one of: %s. "thank_tipper" and "shoutout" need a "target" viewer; "start_poll" needs "question" and "options" (comma separated) params.`,
+        gestures, strings.Join(actions, ", "))
+}
+
+// parseStructuredReply decodes and validates the model's JSON. Unknown
+// gestures are dropped, since a missing animation is harmless, but a
+// malformed object or an unknown action is an error.
+func parseStructuredReply(raw string, config StructuredOutputConfig) (*StructuredReply, error) {
+    var reply StructuredReply
+    decoder := json.NewDecoder(strings.NewReader(stripCodeFence(raw)))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&reply); err != nil {
+        return nil, fmt.Errorf("invalid reply JSON: %w", err)
+    }
+
+    reply.Text = strings.TrimSpace(reply.Text)
+    if reply.Text == "" {
+        return nil, errors.New("reply has no text")
+    }
+
+    reply.Emotion.Label = strings.ToLower(strings.TrimSpace(reply.Emotion.Label))
+    if reply.Emotion.Label == "" {
+        return nil, errors.New("reply has no emotion label")
+    }
+    reply.Emotion.Label = emotionTaxonomy().Canonical(reply.Emotion.Label)
+    if reply.Emotion.Intensity < 0 || reply.Emotion.Intensity > 1 {
+        return nil, fmt.Errorf("emotion intensity %.2f out of range", reply.Emotion.Intensity)
+    }
+
+    if len(config.Gestures) > 0 {
+        kept := reply.Gestures[:0]
+        for _, gesture := range reply.Gestures {
+            if containsString(config.Gestures, gesture) {
+                kept = append(kept, gesture)
+            }
+        }
+        reply.Gestures = kept
+    }
+
+    actions := config.Actions
+    if len(actions) == 0 {
+        actions = defaultResponseActions
+    }
+    for _, action := range reply.Actions {
+        if err := validateResponseAction(action, actions); err != nil {
+            return nil, err
+        }
+    }
+
+    return &reply, nil
+}
+
+func validateResponseAction(action ResponseAction, allowed []string) error {
+    if !containsString(allowed, action.Type) {
+        return fmt.Errorf("unknown action %q", action.Type)
+    }
+
+    switch action.Type {
+    case "thank_tipper", "shoutout":
+        if action.Target == "" {
+            return fmt.Errorf("action %q needs a target", action.Type)
+        }
+    case "start_poll":
+        if action.Params["question"] == "" || action.Params["options"] == "" {
+            return errors.New(`action "start_poll" needs question and options`)
+        }
+    }
+    return nil
+}
+
+// plainTextFromReply salvages something speakable from a reply that failed
+// validation: the "text" field if the JSON has one, otherwise the raw reply
+// unless it is obviously JSON.
+func plainTextFromReply(raw string) string {
+    raw = stripCodeFence(raw)
+
+    var loose map[string]interface{}
+    if err := json.Unmarshal([]byte(raw), &loose); err == nil {
+        if text, ok := loose["text"].(string); ok {
+            return strings.TrimSpace(text)
+        }
+        return ""
+    }
+    if strings.HasPrefix(raw, "{") {
+        return ""
+    }
+    return raw
+}
+
+// Models like to wrap JSON in a markdown code fence even when told not to
+func stripCodeFence(raw string) string {
+    raw = strings.TrimSpace(raw)
+    if !strings.HasPrefix(raw, "```") {
+        return raw
+    }
+
+    raw = strings.TrimPrefix(raw, "```")
+    raw = strings.TrimPrefix(raw, "json")
+    raw = strings.TrimSuffix(raw, "```")
+    return strings.TrimSpace(raw)
+}
+
+func containsString(values []string, target string) bool {
+    for _, value := range values {
+        if value == target {
+            return true
+        }
+    }
+    return false
+}
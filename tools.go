@@ -0,0 +1,281 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/gagliardetto/solana-go"
+)
+
+const defaultMaxToolRounds = 4
+
+type ToolDefinition struct {
+    Name        string
+    Description string
+    Parameters  map[string]interface{} // JSON schema of the arguments object
+}
+
+type ToolCall struct {
+    ID        string `json:"id"`
+    Name      string `json:"name"`
+    Arguments string `json:"arguments"`
+}
+
+// ToolCallRecord is what ends up in ResponseMetadata for each call
+type ToolCallRecord struct {
+    Name      string
+    Arguments string
+    Result    string
+    Error     string
+    Duration  time.Duration
+}
+
+type ToolHandler func(ctx context.Context, args json.RawMessage) (interface{}, error)
+
+type ToolRegistry struct {
+    tools map[string]registeredTool
+    order []string
+    mu    sync.RWMutex
+}
+
+type registeredTool struct {
+    definition ToolDefinition
+    handler    ToolHandler
+}
+
+func NewToolRegistry() *ToolRegistry {
+    return &ToolRegistry{
+        tools: make(map[string]registeredTool),
+    }
+}
+
+func (r *ToolRegistry) Register(definition ToolDefinition, handler ToolHandler) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if definition.Name == "" {
+        return fmt.Errorf("tool name is required")
+    }
+    if _, exists := r.tools[definition.Name]; exists {
+        return fmt.Errorf("tool %q already registered", definition.Name)
+    }
+    if definition.Parameters == nil {
+        definition.Parameters = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+    }
+
+    r.tools[definition.Name] = registeredTool{definition: definition, handler: handler}
+    r.order = append(r.order, definition.Name)
+    return nil
+}
+
+// RegisterTypedTool registers a Go function whose arguments are decoded from
+// the model's JSON into T before it is called.
+func RegisterTypedTool[T any](r *ToolRegistry, definition ToolDefinition, fn func(ctx context.Context, args T) (interface{}, error)) error {
+    return r.Register(definition, func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+        var args T
+        if len(raw) > 0 {
+            if err := json.Unmarshal(raw, &args); err != nil {
+                return nil, fmt.Errorf("invalid arguments: %w", err)
+            }
+        }
+        return fn(ctx, args)
+    })
+}
+
+func (r *ToolRegistry) Definitions() []ToolDefinition {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    definitions := make([]ToolDefinition, 0, len(r.order))
+    for _, name := range r.order {
+        definitions = append(definitions, r.tools[name].definition)
+    }
+    return definitions
+}
+
+// Call runs a tool and records its JSON-encoded result. Failures are
+// reported back to the model as an error object rather than aborting the
+// reply, so the record always carries something to show.
+func (r *ToolRegistry) Call(ctx context.Context, call ToolCall) ToolCallRecord {
+    start := time.Now()
+    record := ToolCallRecord{
+        Name:      call.Name,
+        Arguments: call.Arguments,
+    }
+
+    r.mu.RLock()
+    tool, ok := r.tools[call.Name]
+    r.mu.RUnlock()
+
+    var result interface{}
+    var err error
+    if !ok {
+        err = fmt.Errorf("unknown tool %q", call.Name)
+    } else {
+        result, err = tool.handler(ctx, json.RawMessage(call.Arguments))
+    }
+
+    if err == nil {
+        var encoded []byte
+        encoded, err = json.Marshal(result)
+        record.Result = string(encoded)
+    }
+    if err != nil {
+        record.Error = err.Error()
+        encoded, _ := json.Marshal(map[string]string{"error": err.Error()})
+        record.Result = string(encoded)
+    }
+
+    record.Duration = time.Since(start)
+    return record
+}
+
+// RegisterDefaultTools exposes the VTuber's own stream state to the model.
+// Either manager may be nil when that part of the stream is not running.
+func (l *LLMProcessor) RegisterDefaultTools(streamManager *StreamManager, tipProcessor *TipProcessor) error {
+    if streamManager != nil {
+        err := l.tools.Register(ToolDefinition{
+            Name:        "get_stream_stats",
+            Description: "Current live stream statistics: uptime, viewer count and stream health.",
+        }, func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+            stats, live := streamManager.Stats()
+            return map[string]interface{}{
+                "live":           live,
+                "viewers":        stats.ViewerCount,
+                "uptime_minutes": int(stats.Duration.Minutes()),
+                "health":         stats.Health,
+                "bitrate_kbps":   int(stats.Bitrate / 1000),
+            }, nil
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    if tipProcessor != nil {
+        type recentTipsArgs struct {
+            Limit int `json:"limit"`
+        }
+        err := RegisterTypedTool(l.tools, ToolDefinition{
+            Name:        "get_recent_tips",
+            Description: "The most recent Solana tips received on stream, newest first.",
+            Parameters: map[string]interface{}{
+                "type": "object",
+                "properties": map[string]interface{}{
+                    "limit": map[string]interface{}{"type": "integer", "description": "How many tips to return (max 20)"},
+                },
+            },
+        }, func(ctx context.Context, args recentTipsArgs) (interface{}, error) {
+            if args.Limit <= 0 || args.Limit > 20 {
+                args.Limit = 5
+            }
+
+            tips := tipProcessor.RecentTips(args.Limit)
+            result := make([]map[string]interface{}, 0, len(tips))
+            for _, tip := range tips {
+                result = append(result, map[string]interface{}{
+                    "sender":  tip.Sender.String(),
+                    "sol":     float64(tip.Amount) / float64(solana.LAMPORTS_PER_SOL),
+                    "tier":    tip.RewardTier,
+                    "message": tip.Message,
+                    "time":    tip.Timestamp.Format(time.RFC3339),
+                })
+            }
+            return result, nil
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    type recallArgs struct {
+        Query string `json:"query"`
+        Limit int    `json:"limit"`
+    }
+    err := RegisterTypedTool(l.tools, ToolDefinition{
+        Name:        "recall_memory",
+        Description: "Search your own memories of past streams and conversations.",
+        Parameters: map[string]interface{}{
+            "type": "object",
+            "properties": map[string]interface{}{
+                "query": map[string]interface{}{"type": "string", "description": "What to remember"},
+                "limit": map[string]interface{}{"type": "integer", "description": "Maximum memories to return (max 10)"},
+            },
+            "required": []string{"query"},
+        },
+    }, func(ctx context.Context, args recallArgs) (interface{}, error) {
+        if args.Query == "" {
+            return nil, fmt.Errorf("query is required")
+        }
+        if args.Limit <= 0 || args.Limit > 10 {
+            args.Limit = 5
+        }
+
+        memories := l.memoryBuffer.Recall(ctx, args.Query, args.Limit)
+        result := make([]map[string]interface{}, 0, len(memories))
+        for _, memory := range memories {
+            result = append(result, map[string]interface{}{
+                "content":    memory.Content,
+                "type":       memory.Type,
+                "importance": memory.Importance,
+                "when":       memory.Timestamp.Format(time.RFC3339),
+            })
+        }
+        return result, nil
+    })
+    if err != nil {
+        return err
+    }
+
+    type viewerFactArgs struct {
+        Kind string `json:"kind"`
+        Fact string `json:"fact"`
+    }
+    err = RegisterTypedTool(l.tools, ToolDefinition{
+        Name:        "remember_viewer_fact",
+        Description: "Remember something about the viewer you are answering, e.g. their cat's name or what they like, so you know it next time they talk.",
+        Parameters: map[string]interface{}{
+            "type": "object",
+            "properties": map[string]interface{}{
+                "kind": map[string]interface{}{"type": "string", "enum": viewerFactKinds, "description": "What sort of fact it is"},
+                "fact": map[string]interface{}{"type": "string", "description": "The fact in a short sentence, or their words for a quote"},
+            },
+            "required": []string{"kind", "fact"},
+        },
+    }, func(ctx context.Context, args viewerFactArgs) (interface{}, error) {
+        viewer, ok := speakerFrom(ctx)
+        if !ok {
+            return nil, fmt.Errorf("no viewer is speaking")
+        }
+        if args.Kind == "" {
+            args.Kind = ViewerFact
+        }
+        if err := l.profiles.AddFact(viewer, args.Kind, args.Fact); err != nil {
+            return nil, err
+        }
+        return map[string]interface{}{"remembered": true, "viewer": viewer.DisplayName()}, nil
+    })
+    if err != nil {
+        return err
+    }
+
+    return l.tools.Register(ToolDefinition{
+        Name:        "get_emotion_state",
+        Description: "Your own current emotional state.",
+    }, func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+        state := l.emotionEngine.GetCurrentEmotionalState()
+        return map[string]interface{}{
+            "primary":   state.Primary,
+            "secondary": state.Secondary,
+            "blend":     state.Blend,
+            "compound":  state.Compound,
+            "intensity": state.Intensity,
+            "valence":   state.Valence,
+            "arousal":   state.Arousal,
+            "dominance": state.Dominance,
+        }, nil
+    })
+}
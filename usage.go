@@ -0,0 +1,378 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// USD per 1K tokens. Models that aren't listed (e.g. local ones) are free.
+var defaultModelPrices = map[string]ModelPrice{
+    "gpt-4o-mini":            {Prompt: 0.00015, Completion: 0.0006},
+    "gpt-4o":                 {Prompt: 0.0025, Completion: 0.01},
+    "gpt-4-turbo":            {Prompt: 0.01, Completion: 0.03},
+    "gpt-4":                  {Prompt: 0.03, Completion: 0.06},
+    "gpt-3.5-turbo-instruct": {Prompt: 0.0015, Completion: 0.002},
+    "gpt-3.5-turbo":          {Prompt: 0.0005, Completion: 0.0015},
+    "text-embedding-3-small": {Prompt: 0.00002},
+    "text-embedding-3-large": {Prompt: 0.00013},
+    "text-embedding-ada-002": {Prompt: 0.0001},
+}
+
+type ModelPrice struct {
+    Prompt     float64
+    Completion float64
+}
+
+type UsageConfig struct {
+    Prices           map[string]ModelPrice // merged over the defaults
+    HourlyBudget     float64               // USD over a sliding hour, 0 = unlimited
+    StreamBudget     float64               // USD for the whole stream, 0 = unlimited
+    TightRatio       float64               // share of a budget that starts economy mode
+    EconomyMaxTokens int                   // reply cap while economizing
+    SummaryPath      string                // JSON summary written at stream end
+}
+
+type BudgetLevel int
+
+const (
+    BudgetNormal BudgetLevel = iota
+    BudgetTight              // shorter replies, no LLM emotion analysis
+    BudgetExceeded           // also switch to the cheap model
+)
+
+func (b BudgetLevel) String() string {
+    switch b {
+    case BudgetTight:
+        return "tight"
+    case BudgetExceeded:
+        return "exceeded"
+    default:
+        return "normal"
+    }
+}
+
+type UsageRecord struct {
+    Timestamp        time.Time `json:"timestamp"`
+    Component        string    `json:"component"`
+    Interaction      int       `json:"interaction"`
+    Model            string    `json:"model"`
+    PromptTokens     int       `json:"prompt_tokens"`
+    CompletionTokens int       `json:"completion_tokens"`
+    Cost             float64   `json:"cost"`
+}
+
+type UsageTotals struct {
+    Calls            int     `json:"calls"`
+    PromptTokens     int     `json:"prompt_tokens"`
+    CompletionTokens int     `json:"completion_tokens"`
+    Cost             float64 `json:"cost"`
+}
+
+type UsageSummary struct {
+    Start        time.Time              `json:"start"`
+    End          time.Time              `json:"end"`
+    Total        UsageTotals            `json:"total"`
+    ByComponent  map[string]UsageTotals `json:"by_component"`
+    ByModel      map[string]UsageTotals `json:"by_model"`
+    Interactions int                    `json:"interactions"`
+    PeakLevel    string                 `json:"peak_level"`
+}
+
+// UsageTracker accounts tokens and spend for every LLM call and decides how
+// far to economize when the hourly or per-stream budget runs low.
+type UsageTracker struct {
+    config UsageConfig
+    prices map[string]ModelPrice
+    mu     sync.Mutex
+
+    start       time.Time
+    interaction int
+    lastHour    []UsageRecord
+    summary     UsageSummary
+    level       BudgetLevel
+    peak        BudgetLevel
+}
+
+func NewUsageTracker(config UsageConfig) *UsageTracker {
+    if config.TightRatio <= 0 || config.TightRatio > 1 {
+        config.TightRatio = 0.8
+    }
+    if config.EconomyMaxTokens <= 0 {
+        config.EconomyMaxTokens = 150
+    }
+
+    prices := make(map[string]ModelPrice, len(defaultModelPrices)+len(config.Prices))
+    for model, price := range defaultModelPrices {
+        prices[model] = price
+    }
+    for model, price := range config.Prices {
+        prices[model] = price
+    }
+
+    return &UsageTracker{
+        config: config,
+        prices: prices,
+        start:  time.Now(),
+        summary: UsageSummary{
+            ByComponent: make(map[string]UsageTotals),
+            ByModel:     make(map[string]UsageTotals),
+        },
+    }
+}
+
+// BeginInteraction attributes the following calls to a viewer interaction
+func (t *UsageTracker) BeginInteraction(interaction int) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.interaction = interaction
+    if interaction > t.summary.Interactions {
+        t.summary.Interactions = interaction
+    }
+}
+
+func (t *UsageTracker) Record(component string, model string, promptTokens int, completionTokens int) UsageRecord {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    record := UsageRecord{
+        Timestamp:        time.Now(),
+        Component:        component,
+        Interaction:      t.interaction,
+        Model:            model,
+        PromptTokens:     promptTokens,
+        CompletionTokens: completionTokens,
+        Cost:             t.cost(model, promptTokens, completionTokens),
+    }
+
+    t.lastHour = append(t.lastHour, record)
+    addUsage(&t.summary.Total, record)
+    t.summary.ByComponent[component] = addedUsage(t.summary.ByComponent[component], record)
+    t.summary.ByModel[model] = addedUsage(t.summary.ByModel[model], record)
+
+    t.updateLevel(record.Timestamp)
+    return record
+}
+
+// Prices are looked up by longest prefix, since the API reports dated
+// snapshots like gpt-4o-2024-08-06
+func (t *UsageTracker) cost(model string, promptTokens int, completionTokens int) float64 {
+    var price ModelPrice
+    matched := ""
+    for name, p := range t.prices {
+        if strings.HasPrefix(model, name) && len(name) > len(matched) {
+            matched, price = name, p
+        }
+    }
+    return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1000
+}
+
+func (t *UsageTracker) updateLevel(now time.Time) {
+    cutoff := now.Add(-time.Hour)
+    kept := t.lastHour[:0]
+    hourly := 0.0
+    for _, record := range t.lastHour {
+        if record.Timestamp.After(cutoff) {
+            kept = append(kept, record)
+            hourly += record.Cost
+        }
+    }
+    t.lastHour = kept
+
+    level := maxBudgetLevel(
+        budgetLevel(hourly, t.config.HourlyBudget, t.config.TightRatio),
+        budgetLevel(t.summary.Total.Cost, t.config.StreamBudget, t.config.TightRatio),
+    )
+    if level != t.level {
+        log.Printf("LLM budget level %s -> %s (hour $%.4f, stream $%.4f)", t.level, level, hourly, t.summary.Total.Cost)
+        t.level = level
+    }
+    if level > t.peak {
+        t.peak = level
+    }
+}
+
+func budgetLevel(spent float64, budget float64, tightRatio float64) BudgetLevel {
+    switch {
+    case budget <= 0:
+        return BudgetNormal
+    case spent >= budget:
+        return BudgetExceeded
+    case spent >= budget*tightRatio:
+        return BudgetTight
+    default:
+        return BudgetNormal
+    }
+}
+
+func maxBudgetLevel(a BudgetLevel, b BudgetLevel) BudgetLevel {
+    if a > b {
+        return a
+    }
+    return b
+}
+
+func (t *UsageTracker) Level() BudgetLevel {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    // Spend ages out of the hourly window even when nothing is recorded
+    t.updateLevel(time.Now())
+    return t.level
+}
+
+// AllowEmotionAnalysis is false once we are economizing; chat is treated as
+// neutral until spend recovers.
+func (t *UsageTracker) AllowEmotionAnalysis() bool {
+    return t.Level() == BudgetNormal
+}
+
+// Adjust degrades a request to fit the current budget level
+func (t *UsageTracker) Adjust(req ChatRequest) ChatRequest {
+    level := t.Level()
+    if level >= BudgetTight && (req.MaxTokens == 0 || req.MaxTokens > t.config.EconomyMaxTokens) {
+        req.MaxTokens = t.config.EconomyMaxTokens
+    }
+    if level >= BudgetExceeded {
+        req.Cheap = true
+    }
+    return req
+}
+
+func (t *UsageTracker) Summary() UsageSummary {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    summary := t.summary
+    summary.Start = t.start
+    summary.End = time.Now()
+    summary.PeakLevel = t.peak.String()
+
+    summary.ByComponent = make(map[string]UsageTotals, len(t.summary.ByComponent))
+    for component, totals := range t.summary.ByComponent {
+        summary.ByComponent[component] = totals
+    }
+    summary.ByModel = make(map[string]UsageTotals, len(t.summary.ByModel))
+    for model, totals := range t.summary.ByModel {
+        summary.ByModel[model] = totals
+    }
+    return summary
+}
+
+// WriteSummary logs the stream's usage and saves it to SummaryPath if set.
+// Call it when the stream ends.
+func (t *UsageTracker) WriteSummary() error {
+    summary := t.Summary()
+    log.Printf("LLM usage: %d calls, %d prompt + %d completion tokens, $%.4f",
+        summary.Total.Calls, summary.Total.PromptTokens, summary.Total.CompletionTokens, summary.Total.Cost)
+
+    if t.config.SummaryPath == "" {
+        return nil
+    }
+
+    data, err := json.MarshalIndent(summary, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to encode usage summary: %w", err)
+    }
+    if err := os.WriteFile(t.config.SummaryPath, data, 0o644); err != nil {
+        return fmt.Errorf("failed to write usage summary: %w", err)
+    }
+    return nil
+}
+
+func addUsage(totals *UsageTotals, record UsageRecord) {
+    totals.Calls++
+    totals.PromptTokens += record.PromptTokens
+    totals.CompletionTokens += record.CompletionTokens
+    totals.Cost += record.Cost
+}
+
+func addedUsage(totals UsageTotals, record UsageRecord) UsageTotals {
+    addUsage(&totals, record)
+    return totals
+}
+
+// MeteredBackend records the usage of every call for one component and
+// applies the tracker's budget degradation to the request.
+type MeteredBackend struct {
+    ChatBackend
+    tracker   *UsageTracker
+    component string
+}
+
+func NewMeteredBackend(backend ChatBackend, tracker *UsageTracker, component string) *MeteredBackend {
+    return &MeteredBackend{ChatBackend: backend, tracker: tracker, component: component}
+}
+
+func (b *MeteredBackend) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+    req = b.tracker.Adjust(req)
+    resp, err := b.ChatBackend.CreateChatCompletion(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+
+    model := resp.Model
+    if model == "" {
+        model = req.Model
+    }
+    b.tracker.Record(b.component, model, resp.PromptTokens, resp.CompletionTokens)
+    return resp, nil
+}
+
+func (b *MeteredBackend) CreateChatCompletionStream(ctx context.Context, req ChatRequest) (ChatStream, error) {
+    req = b.tracker.Adjust(req)
+    stream, err := b.ChatBackend.CreateChatCompletionStream(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    return &meteredChatStream{ChatStream: stream, backend: b, request: req}, nil
+}
+
+// Health passes through to the wrapped backend's breaker state
+func (b *MeteredBackend) Health() HealthStatus {
+    if reporter, ok := b.ChatBackend.(interface{ Health() HealthStatus }); ok {
+        return reporter.Health()
+    }
+    return HealthStatus{Healthy: true, Detail: b.Name()}
+}
+
+type meteredChatStream struct {
+    ChatStream
+    backend   *MeteredBackend
+    request   ChatRequest
+    completed strings.Builder
+    recorded  bool
+}
+
+func (s *meteredChatStream) Recv() (string, error) {
+    token, err := s.ChatStream.Recv()
+    s.completed.WriteString(token)
+    return token, err
+}
+
+// Usage is recorded on Close, estimating it when the server didn't report any
+func (s *meteredChatStream) Close() error {
+    if !s.recorded {
+        s.recorded = true
+
+        usage := s.ChatStream.Usage()
+        if usage.Model == "" {
+            usage.Model = s.request.Model
+        }
+        if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+            tokenizer := ApproxTokenizer{}
+            for _, msg := range s.request.Messages {
+                usage.PromptTokens += tokenizer.CountTokens(msg.Content) + messageTokenOverhead
+            }
+            usage.CompletionTokens = tokenizer.CountTokens(s.completed.String())
+        }
+        s.backend.tracker.Record(s.backend.component, usage.Model, usage.PromptTokens, usage.CompletionTokens)
+    }
+    return s.ChatStream.Close()
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gagliardetto/solana-go"
+)
+
+type ViewerProfilesConfig struct {
+    Path     string // JSON file, empty keeps profiles in RAM only
+    MaxNotes int    // per kind, the oldest are forgotten first
+}
+
+// Kinds of facts a profile keeps
+const (
+    ViewerNickname     = "nickname"
+    ViewerPreference   = "preference"
+    ViewerQuote        = "quote"
+    ViewerRelationship = "relationship"
+    ViewerFact         = "fact"
+)
+
+var viewerFactKinds = []string{ViewerNickname, ViewerPreference, ViewerQuote, ViewerRelationship, ViewerFact}
+
+var viewerFactLabels = map[string]string{
+    ViewerNickname:     "Goes by",
+    ViewerPreference:   "Likes",
+    ViewerQuote:        "Once said",
+    ViewerRelationship: "Between you",
+    ViewerFact:         "Fact",
+}
+
+type ViewerNote struct {
+    Text  string    `json:"text"`
+    Added time.Time `json:"added"`
+}
+
+// ViewerProfile is what the VTuber knows about one viewer, whichever chat
+// handle or wallet they show up with
+type ViewerProfile struct {
+    ID           string       `json:"id"`
+    Handles      []string     `json:"handles"`
+    Wallets      []string     `json:"wallets"`
+    FirstSeen    time.Time    `json:"first_seen"`
+    LastSeen     time.Time    `json:"last_seen"`
+    Nicknames    []ViewerNote `json:"nicknames,omitempty"`
+    Preferences  []ViewerNote `json:"preferences,omitempty"`
+    Quotes       []ViewerNote `json:"quotes,omitempty"`
+    Relationship []ViewerNote `json:"relationship,omitempty"`
+    Facts        []ViewerNote `json:"facts,omitempty"`
+    TipLamports  uint64       `json:"tip_lamports"`
+    TipCount     int          `json:"tip_count"`
+    LastTip      time.Time    `json:"last_tip,omitempty"`
+}
+
+func (p *ViewerProfile) notes(kind string) *[]ViewerNote {
+    switch kind {
+    case ViewerNickname:
+        return &p.Nicknames
+    case ViewerPreference:
+        return &p.Preferences
+    case ViewerQuote:
+        return &p.Quotes
+    case ViewerRelationship:
+        return &p.Relationship
+    case ViewerFact:
+        return &p.Facts
+    }
+    return nil
+}
+
+func (p ViewerProfile) clone() ViewerProfile {
+    p.Handles = append([]string(nil), p.Handles...)
+    p.Wallets = append([]string(nil), p.Wallets...)
+    for _, kind := range viewerFactKinds {
+        notes := p.notes(kind)
+        *notes = append([]ViewerNote(nil), *notes...)
+    }
+    return p
+}
+
+// ViewerProfiles links chat handles and Solana wallets to one profile per
+// viewer. A viewer who tipped before chatting from the same wallet ends up
+// with a single merged profile.
+type ViewerProfiles struct {
+    config   ViewerProfilesConfig
+    mu       sync.Mutex
+    profiles map[string]*ViewerProfile
+    byHandle map[string]string // lowercase handle -> profile ID
+    byWallet map[string]string
+    dirty    bool
+}
+
+func NewViewerProfiles(config ViewerProfilesConfig) (*ViewerProfiles, error) {
+    if config.MaxNotes <= 0 {
+        config.MaxNotes = 20
+    }
+
+    p := &ViewerProfiles{
+        config:   config,
+        profiles: make(map[string]*ViewerProfile),
+        byHandle: make(map[string]string),
+        byWallet: make(map[string]string),
+    }
+    if config.Path == "" {
+        return p, nil
+    }
+
+    data, err := os.ReadFile(config.Path)
+    if errors.Is(err, os.ErrNotExist) {
+        return p, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read viewer profiles: %w", err)
+    }
+    var profiles []*ViewerProfile
+    if err := json.Unmarshal(data, &profiles); err != nil {
+        return nil, fmt.Errorf("failed to parse viewer profiles: %w", err)
+    }
+    for _, profile := range profiles {
+        p.index(profile)
+    }
+    return p, nil
+}
+
+// Observe records that viewer was seen and links their handle and wallet
+func (p *ViewerProfiles) Observe(viewer ViewerIdentity, at time.Time) {
+    if viewer.IsAnonymous() {
+        return
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    created := len(p.profiles)
+    profile := p.profileFor(viewer, at)
+    if at.After(profile.LastSeen) {
+        profile.LastSeen = at
+    }
+    p.dirty = true
+    if len(p.profiles) != created {
+        p.saveLocked()
+    }
+}
+
+// RecordTip adds a tip to the sender's running total
+func (p *ViewerProfiles) RecordTip(tip TipEvent) {
+    if tip.Sender.IsZero() {
+        return
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    profile := p.profileFor(ViewerIdentity{Wallet: tip.Sender.String()}, tip.Timestamp)
+    profile.TipLamports += tip.Amount
+    profile.TipCount++
+    if tip.Timestamp.After(profile.LastTip) {
+        profile.LastTip = tip.Timestamp
+    }
+    p.dirty = true
+    p.saveLocked()
+}
+
+// AddFact remembers something about viewer. Facts already known are not
+// added twice.
+func (p *ViewerProfiles) AddFact(viewer ViewerIdentity, kind string, text string) error {
+    text = strings.TrimSpace(text)
+    if viewer.IsAnonymous() {
+        return errors.New("viewer is anonymous")
+    }
+    if text == "" {
+        return errors.New("fact is empty")
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    profile := p.profileFor(viewer, time.Now())
+    notes := profile.notes(kind)
+    if notes == nil {
+        return fmt.Errorf("unknown fact kind %q", kind)
+    }
+    for _, note := range *notes {
+        if strings.EqualFold(note.Text, text) {
+            return nil
+        }
+    }
+
+    *notes = append(*notes, ViewerNote{Text: text, Added: time.Now()})
+    if len(*notes) > p.config.MaxNotes {
+        *notes = (*notes)[len(*notes)-p.config.MaxNotes:]
+    }
+    p.dirty = true
+    p.saveLocked()
+    return nil
+}
+
+func (p *ViewerProfiles) Get(viewer ViewerIdentity) (ViewerProfile, bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if profile := p.lookup(viewer); profile != nil {
+        return profile.clone(), true
+    }
+    return ViewerProfile{}, false
+}
+
+// Message describes viewer for the prompt, empty for viewers we know
+// nothing about yet
+func (p *ViewerProfiles) Message(viewer ViewerIdentity) Message {
+    profile, ok := p.Get(viewer)
+    if !ok {
+        return Message{}
+    }
+
+    var lines []string
+    for _, kind := range viewerFactKinds {
+        for _, note := range *profile.notes(kind) {
+            lines = append(lines, fmt.Sprintf("- %s: %s", viewerFactLabels[kind], note.Text))
+        }
+    }
+
+    about := fmt.Sprintf("first seen %s", profile.FirstSeen.Format("Jan 2, 2006"))
+    if profile.TipCount > 0 {
+        about += fmt.Sprintf(", tipped %.3g SOL over %d tips", float64(profile.TipLamports)/float64(solana.LAMPORTS_PER_SOL), profile.TipCount)
+    }
+    content := fmt.Sprintf("What you know about %s (%s):", viewer.DisplayName(), about)
+    if len(lines) > 0 {
+        content += "\n" + strings.Join(lines, "\n")
+    }
+
+    return Message{
+        Role:      "system",
+        Content:   content,
+        Timestamp: profile.LastSeen,
+    }
+}
+
+// Close saves profiles whose last-seen times changed since the last save
+func (p *ViewerProfiles) Close() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    return p.save()
+}
+
+func (p *ViewerProfiles) lookup(viewer ViewerIdentity) *ViewerProfile {
+    if id, ok := p.byHandle[strings.ToLower(viewer.Handle)]; ok && viewer.Handle != "" {
+        return p.profiles[id]
+    }
+    if id, ok := p.byWallet[viewer.Wallet]; ok && viewer.Wallet != "" {
+        return p.profiles[id]
+    }
+    return nil
+}
+
+// profileFor finds or creates viewer's profile, linking a new handle or
+// wallet to it and merging two profiles the viewer turns out to share.
+// Callers hold p.mu.
+func (p *ViewerProfiles) profileFor(viewer ViewerIdentity, at time.Time) *ViewerProfile {
+    var byHandle, byWallet *ViewerProfile
+    if viewer.Handle != "" {
+        byHandle = p.profiles[p.byHandle[strings.ToLower(viewer.Handle)]]
+    }
+    if viewer.Wallet != "" {
+        byWallet = p.profiles[p.byWallet[viewer.Wallet]]
+    }
+
+    profile := byHandle
+    switch {
+    case byHandle != nil && byWallet != nil && byHandle != byWallet:
+        p.merge(byHandle, byWallet)
+    case byHandle == nil && byWallet != nil:
+        profile = byWallet
+    case byHandle == nil:
+        profile = &ViewerProfile{ID: viewer.Key(), FirstSeen: at, LastSeen: at}
+    }
+
+    if viewer.Handle != "" && byHandle == nil {
+        profile.Handles = append(profile.Handles, viewer.Handle)
+    }
+    if viewer.Wallet != "" && byWallet == nil {
+        profile.Wallets = append(profile.Wallets, viewer.Wallet)
+    }
+    p.index(profile)
+    return profile
+}
+
+// merge folds from into into, keeping the earliest first sighting
+func (p *ViewerProfiles) merge(into *ViewerProfile, from *ViewerProfile) {
+    into.Handles = append(into.Handles, from.Handles...)
+    into.Wallets = append(into.Wallets, from.Wallets...)
+    if from.FirstSeen.Before(into.FirstSeen) {
+        into.FirstSeen = from.FirstSeen
+    }
+    if from.LastSeen.After(into.LastSeen) {
+        into.LastSeen = from.LastSeen
+    }
+    if from.LastTip.After(into.LastTip) {
+        into.LastTip = from.LastTip
+    }
+    into.TipLamports += from.TipLamports
+    into.TipCount += from.TipCount
+    for _, kind := range viewerFactKinds {
+        notes := into.notes(kind)
+        *notes = mergeNotes(*notes, *from.notes(kind), p.config.MaxNotes)
+    }
+
+    delete(p.profiles, from.ID)
+    p.index(into)
+    log.Printf("Merged viewer profile %s into %s", from.ID, into.ID)
+}
+
+// mergeNotes combines two profiles' notes oldest first, keeping the first of
+// any duplicates (ignoring case, like AddFact) and the newest max notes
+func mergeNotes(a []ViewerNote, b []ViewerNote, max int) []ViewerNote {
+    all := append(append([]ViewerNote{}, a...), b...)
+    sort.SliceStable(all, func(i, j int) bool {
+        return all[i].Added.Before(all[j].Added)
+    })
+
+    merged := all[:0]
+    seen := make(map[string]bool, len(all))
+    for _, note := range all {
+        key := strings.ToLower(note.Text)
+        if seen[key] {
+            continue
+        }
+        seen[key] = true
+        merged = append(merged, note)
+    }
+    if len(merged) > max {
+        merged = merged[len(merged)-max:]
+    }
+    return merged
+}
+
+func (p *ViewerProfiles) index(profile *ViewerProfile) {
+    p.profiles[profile.ID] = profile
+    for _, handle := range profile.Handles {
+        p.byHandle[strings.ToLower(handle)] = profile.ID
+    }
+    for _, wallet := range profile.Wallets {
+        p.byWallet[wallet] = profile.ID
+    }
+}
+
+func (p *ViewerProfiles) saveLocked() {
+    if err := p.save(); err != nil {
+        log.Printf("Failed to save viewer profiles: %v", err)
+    }
+}
+
+// save replaces the profiles file through a temp file so a crash never
+// leaves it half written. Callers hold p.mu.
+func (p *ViewerProfiles) save() error {
+    if p.config.Path == "" || !p.dirty {
+        return nil
+    }
+
+    profiles := make([]*ViewerProfile, 0, len(p.profiles))
+    for _, profile := range p.profiles {
+        profiles = append(profiles, profile)
+    }
+    data, err := json.MarshalIndent(profiles, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    dir := filepath.Dir(p.config.Path)
+    tmp, err := os.CreateTemp(dir, ".viewers-*.json")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp.Name(), p.config.Path); err != nil {
+        return err
+    }
+    syncDir(dir)
+
+    p.dirty = false
+    return nil
+}
+
+type speakerKey struct{}
+
+// withSpeaker tells tools which viewer the current turn answers
+func withSpeaker(ctx context.Context, viewer ViewerIdentity) context.Context {
+    return context.WithValue(ctx, speakerKey{}, viewer)
+}
+
+func speakerFrom(ctx context.Context) (ViewerIdentity, bool) {
+    viewer, ok := ctx.Value(speakerKey{}).(ViewerIdentity)
+    return viewer, ok && !viewer.IsAnonymous()
+}
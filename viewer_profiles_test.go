@@ -0,0 +1,252 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/gagliardetto/solana-go"
+)
+
+func TestViewerProfilesLinkIdentities(t *testing.T) {
+    wallet := solana.NewWallet().PublicKey()
+    start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    at := func(minutes int) time.Time {
+        return start.Add(time.Duration(minutes) * time.Minute)
+    }
+    tip := func(minutes int, lamports uint64) func(p *ViewerProfiles) {
+        return func(p *ViewerProfiles) {
+            p.RecordTip(TipEvent{Sender: wallet, Amount: lamports, Timestamp: at(minutes)})
+        }
+    }
+    observe := func(minutes int, viewer ViewerIdentity) func(p *ViewerProfiles) {
+        return func(p *ViewerProfiles) {
+            p.Observe(viewer, at(minutes))
+        }
+    }
+    alice := ViewerIdentity{Handle: "Alice"}
+    aliceWallet := ViewerIdentity{Handle: "alice", Wallet: wallet.String()}
+
+    tests := []struct {
+        name          string
+        steps         []func(p *ViewerProfiles)
+        wantProfiles  int
+        wantTips      int
+        wantFirstSeen time.Time
+    }{
+        {
+            name:          "handles are case-insensitive",
+            steps:         []func(p *ViewerProfiles){observe(0, alice), observe(5, ViewerIdentity{Handle: "ALICE"})},
+            wantProfiles:  1,
+            wantFirstSeen: at(0),
+        },
+        {
+            name:          "other handles get their own profile",
+            steps:         []func(p *ViewerProfiles){observe(0, alice), observe(1, ViewerIdentity{Handle: "bob"})},
+            wantProfiles:  2,
+            wantFirstSeen: at(0),
+        },
+        {
+            name:          "tipper who starts chatting keeps their profile",
+            steps:         []func(p *ViewerProfiles){tip(0, 1000), observe(5, aliceWallet)},
+            wantProfiles:  1,
+            wantTips:      1,
+            wantFirstSeen: at(0),
+        },
+        {
+            name:          "chatter and tipper merge once linked",
+            steps:         []func(p *ViewerProfiles){tip(10, 1000), observe(0, alice), tip(20, 500), observe(30, aliceWallet)},
+            wantProfiles:  1,
+            wantTips:      2,
+            wantFirstSeen: at(0),
+        },
+        {
+            name:          "anonymous viewers are ignored",
+            steps:         []func(p *ViewerProfiles){observe(0, ViewerIdentity{})},
+            wantProfiles:  0,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p, err := NewViewerProfiles(ViewerProfilesConfig{})
+            if err != nil {
+                t.Fatalf("NewViewerProfiles: %v", err)
+            }
+            for _, step := range tt.steps {
+                step(p)
+            }
+
+            if len(p.profiles) != tt.wantProfiles {
+                t.Fatalf("got %d profiles, want %d", len(p.profiles), tt.wantProfiles)
+            }
+            if tt.wantProfiles == 0 {
+                return
+            }
+
+            profile, ok := p.Get(alice)
+            if !ok {
+                t.Fatal("alice has no profile")
+            }
+            if profile.TipCount != tt.wantTips {
+                t.Errorf("tip count = %d, want %d", profile.TipCount, tt.wantTips)
+            }
+            if !profile.FirstSeen.Equal(tt.wantFirstSeen) {
+                t.Errorf("first seen = %v, want %v", profile.FirstSeen, tt.wantFirstSeen)
+            }
+            if tt.wantTips > 0 {
+                byWallet, ok := p.Get(ViewerIdentity{Wallet: wallet.String()})
+                if !ok || byWallet.ID != profile.ID {
+                    t.Errorf("wallet resolves to %q, handle to %q", byWallet.ID, profile.ID)
+                }
+            }
+        })
+    }
+}
+
+func TestViewerProfilesMergeKeepsEverything(t *testing.T) {
+    wallet := solana.NewWallet().PublicKey()
+    p, err := NewViewerProfiles(ViewerProfilesConfig{})
+    if err != nil {
+        t.Fatalf("NewViewerProfiles: %v", err)
+    }
+
+    alice := ViewerIdentity{Handle: "alice"}
+    p.Observe(alice, time.Now())
+    if err := p.AddFact(alice, ViewerPreference, "strawberry cake"); err != nil {
+        t.Fatalf("AddFact: %v", err)
+    }
+    p.RecordTip(TipEvent{Sender: wallet, Amount: 2 * solana.LAMPORTS_PER_SOL, Timestamp: time.Now()})
+    if err := p.AddFact(ViewerIdentity{Wallet: wallet.String()}, ViewerNickname, "cake witch"); err != nil {
+        t.Fatalf("AddFact: %v", err)
+    }
+
+    p.Observe(ViewerIdentity{Handle: "alice", Wallet: wallet.String()}, time.Now())
+
+    profile, _ := p.Get(alice)
+    if len(profile.Preferences) != 1 || len(profile.Nicknames) != 1 {
+        t.Errorf("facts lost in merge: %+v", profile)
+    }
+    if profile.TipLamports != 2*solana.LAMPORTS_PER_SOL {
+        t.Errorf("tip lamports = %d", profile.TipLamports)
+    }
+    if len(profile.Handles) != 1 || len(profile.Wallets) != 1 {
+        t.Errorf("handles %v wallets %v", profile.Handles, profile.Wallets)
+    }
+}
+
+func TestViewerProfilesMergeDedupesNotes(t *testing.T) {
+    wallet := ViewerIdentity{Wallet: solana.NewWallet().PublicKey().String()}
+    p, err := NewViewerProfiles(ViewerProfilesConfig{MaxNotes: 2})
+    if err != nil {
+        t.Fatalf("NewViewerProfiles: %v", err)
+    }
+
+    alice := ViewerIdentity{Handle: "alice"}
+    facts := []struct {
+        viewer ViewerIdentity
+        kind   string
+        text   string
+    }{
+        {alice, ViewerNickname, "Ali"},
+        {alice, ViewerFact, "a"},
+        {alice, ViewerFact, "b"},
+        {wallet, ViewerNickname, "ali"},
+        {wallet, ViewerFact, "A"},
+        {wallet, ViewerFact, "c"},
+    }
+    for _, fact := range facts {
+        if err := p.AddFact(fact.viewer, fact.kind, fact.text); err != nil {
+            t.Fatalf("AddFact: %v", err)
+        }
+    }
+
+    p.Observe(ViewerIdentity{Handle: "alice", Wallet: wallet.Wallet}, time.Now())
+
+    profile, _ := p.Get(alice)
+    if len(profile.Nicknames) != 1 || profile.Nicknames[0].Text != "Ali" {
+        t.Errorf("nicknames = %+v, want just Ali", profile.Nicknames)
+    }
+    if len(profile.Facts) != 2 || profile.Facts[0].Text != "b" || profile.Facts[1].Text != "c" {
+        t.Errorf("facts = %+v, want b and c", profile.Facts)
+    }
+}
+
+func TestViewerProfilesAddFact(t *testing.T) {
+    alice := ViewerIdentity{Handle: "alice"}
+
+    tests := []struct {
+        name      string
+        viewer    ViewerIdentity
+        kind      string
+        facts     []string
+        wantNotes []string
+        wantErr   bool
+    }{
+        {name: "added", viewer: alice, kind: ViewerPreference, facts: []string{"cake"}, wantNotes: []string{"cake"}},
+        {name: "duplicates ignored", viewer: alice, kind: ViewerPreference, facts: []string{"cake", " Cake "}, wantNotes: []string{"cake"}},
+        {name: "oldest forgotten", viewer: alice, kind: ViewerFact, facts: []string{"a", "b", "c"}, wantNotes: []string{"b", "c"}},
+        {name: "unknown kind", viewer: alice, kind: "secret", facts: []string{"x"}, wantErr: true},
+        {name: "empty fact", viewer: alice, kind: ViewerFact, facts: []string{"  "}, wantErr: true},
+        {name: "anonymous viewer", viewer: ViewerIdentity{}, kind: ViewerFact, facts: []string{"x"}, wantErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p, err := NewViewerProfiles(ViewerProfilesConfig{MaxNotes: 2})
+            if err != nil {
+                t.Fatalf("NewViewerProfiles: %v", err)
+            }
+
+            var lastErr error
+            for _, fact := range tt.facts {
+                if err := p.AddFact(tt.viewer, tt.kind, fact); err != nil {
+                    lastErr = err
+                }
+            }
+            if (lastErr != nil) != tt.wantErr {
+                t.Fatalf("err = %v, want error %v", lastErr, tt.wantErr)
+            }
+            if tt.wantErr {
+                return
+            }
+
+            profile, _ := p.Get(tt.viewer)
+            notes := *profile.notes(tt.kind)
+            if len(notes) != len(tt.wantNotes) {
+                t.Fatalf("notes = %+v, want %v", notes, tt.wantNotes)
+            }
+            for i, note := range notes {
+                if note.Text != tt.wantNotes[i] {
+                    t.Errorf("note %d = %q, want %q", i, note.Text, tt.wantNotes[i])
+                }
+            }
+        })
+    }
+}
+
+func TestViewerProfilesPersist(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "viewers.json")
+    wallet := solana.NewWallet().PublicKey().String()
+
+    p, err := NewViewerProfiles(ViewerProfilesConfig{Path: path})
+    if err != nil {
+        t.Fatalf("NewViewerProfiles: %v", err)
+    }
+    p.Observe(ViewerIdentity{Handle: "alice", Wallet: wallet}, time.Now())
+    if err := p.AddFact(ViewerIdentity{Handle: "alice"}, ViewerQuote, "gm chat"); err != nil {
+        t.Fatalf("AddFact: %v", err)
+    }
+    if err := p.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+
+    reloaded, err := NewViewerProfiles(ViewerProfilesConfig{Path: path})
+    if err != nil {
+        t.Fatalf("reload: %v", err)
+    }
+    profile, ok := reloaded.Get(ViewerIdentity{Wallet: wallet})
+    if !ok || len(profile.Quotes) != 1 || profile.Quotes[0].Text != "gm chat" {
+        t.Errorf("reloaded profile = %+v, found %v", profile, ok)
+    }
+}
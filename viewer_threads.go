@@ -0,0 +1,145 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+type ViewerIdentity struct {
+    Handle string `json:"handle"`
+    Wallet string `json:"wallet,omitempty"` // base58 Solana address, optional
+}
+
+func (v ViewerIdentity) IsAnonymous() bool {
+    return v.Handle == "" && v.Wallet == ""
+}
+
+// Key identifies a viewer across messages. Handles are case-insensitive in
+// pump.fun chat; wallet-only viewers (e.g. tippers) fall back to the address.
+func (v ViewerIdentity) Key() string {
+    if v.Handle != "" {
+        return strings.ToLower(v.Handle)
+    }
+    return v.Wallet
+}
+
+func (v ViewerIdentity) DisplayName() string {
+    if v.Handle != "" {
+        return v.Handle
+    }
+    if len(v.Wallet) > 8 {
+        return v.Wallet[:4] + ".." + v.Wallet[len(v.Wallet)-4:]
+    }
+    return v.Wallet
+}
+
+// ViewerThreads keeps a short per-viewer history next to the shared context
+// window, so the VTuber can follow up on what a viewer said earlier even
+// after busy chat has pushed it out of the window.
+type ViewerThreads struct {
+    threads    map[string]*viewerThread
+    maxTurns   int // exchanges kept per viewer, two messages each
+    maxViewers int
+    mu         sync.Mutex
+}
+
+type viewerThread struct {
+    viewer   ViewerIdentity
+    messages []Message
+    lastSeen time.Time
+}
+
+func NewViewerThreads(maxTurns int, maxViewers int) *ViewerThreads {
+    if maxTurns <= 0 {
+        maxTurns = 10
+    }
+    if maxViewers <= 0 {
+        maxViewers = 500
+    }
+
+    return &ViewerThreads{
+        threads:    make(map[string]*viewerThread),
+        maxTurns:   maxTurns,
+        maxViewers: maxViewers,
+    }
+}
+
+// Record appends a viewer message and the reply to it to that viewer's thread
+func (t *ViewerThreads) Record(input Message, reply Message) {
+    if input.Viewer == nil || input.Viewer.IsAnonymous() {
+        return
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    key := input.Viewer.Key()
+    thread, ok := t.threads[key]
+    if !ok {
+        if len(t.threads) >= t.maxViewers {
+            t.evictOldest()
+        }
+        thread = &viewerThread{}
+        t.threads[key] = thread
+    }
+
+    // Keep the latest identity so a wallet learned later sticks
+    thread.viewer = *input.Viewer
+    thread.lastSeen = input.Timestamp
+    thread.messages = append(thread.messages, input, reply)
+    if limit := t.maxTurns * 2; len(thread.messages) > limit {
+        thread.messages = thread.messages[len(thread.messages)-limit:]
+    }
+}
+
+// Context returns the viewer's earlier exchanges that are older than the
+// shared window, formatted as a system message. The message is empty when
+// there is nothing the model can't already see.
+func (t *ViewerThreads) Context(viewer ViewerIdentity, windowStart time.Time) Message {
+    if viewer.IsAnonymous() {
+        return Message{}
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    thread, ok := t.threads[viewer.Key()]
+    if !ok {
+        return Message{}
+    }
+
+    var lines []string
+    for _, msg := range thread.messages {
+        if !windowStart.IsZero() && !msg.Timestamp.Before(windowStart) {
+            continue
+        }
+        speaker := "You"
+        if msg.Role == "user" {
+            speaker = thread.viewer.DisplayName()
+        }
+        lines = append(lines, fmt.Sprintf("- %s: %s", speaker, msg.Content))
+    }
+    if len(lines) == 0 {
+        return Message{}
+    }
+
+    return Message{
+        Role:      "system",
+        Content:   fmt.Sprintf("Earlier exchanges with %s:\n%s", thread.viewer.DisplayName(), strings.Join(lines, "\n")),
+        Timestamp: thread.lastSeen,
+    }
+}
+
+func (t *ViewerThreads) evictOldest() {
+    var oldestKey string
+    var oldest time.Time
+    for key, thread := range t.threads {
+        if oldestKey == "" || thread.lastSeen.Before(oldest) {
+            oldestKey = key
+            oldest = thread.lastSeen
+        }
+    }
+    delete(t.threads, oldestKey)
+}
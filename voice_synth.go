@@ -3,6 +3,7 @@ package main
 import (
     "context"
     "io"
+    "strings"
     "time"
     "sync"
 
@@ -25,6 +26,8 @@ type VoiceSynthesizer struct {
     // Emotion modulation
     emotionModifiers map[string]VoiceModifier
     currentEmotion   string
+    currentSecondary string
+    currentBlend     float64
 }
 
 type VoiceConfig struct {
@@ -36,19 +39,44 @@ type VoiceConfig struct {
     PitchRange     [2]float64
     RateRange      [2]float64
     VolumeRange    [2]float64
+    Voices         map[string]VoiceSelection // by ISO 639-1 code
+}
+
+type VoiceSelection struct {
+    LanguageCode string
+    Name         string
+    Gender       texttospeechpb.SsmlVoiceGender
+}
+
+// Used for languages without a configured voice
+var defaultVoiceLanguageCodes = map[string]string{
+    "en": "en-US",
+    "ja": "ja-JP",
+    "es": "es-US",
+    "pt": "pt-BR",
 }
 
 type VoiceModifier struct {
-    PitchMod     float64
-    RateMod      float64
-    VolumeMod    float64
-    EffectChain  []AudioEffect
+    PitchMod     float64       `json:"pitch"`
+    RateMod      float64       `json:"rate"`
+    VolumeMod    float64       `json:"volume"`
+    EffectChain  []AudioEffect `json:"effects"`
 }
 
 type AudioEffect struct {
-    Type      string
-    Intensity float64
-    Params    map[string]float64
+    Type      string             `json:"type"`
+    Intensity float64            `json:"intensity"`
+    Params    map[string]float64 `json:"params"`
+}
+
+// initializeEmotionModifiers takes the voice modifiers from the emotion taxonomy
+func initializeEmotionModifiers() map[string]VoiceModifier {
+    taxonomy := emotionTaxonomy()
+    modifiers := make(map[string]VoiceModifier, len(taxonomy.Names()))
+    for _, name := range taxonomy.Names() {
+        modifiers[name] = taxonomy.Get(name).Voice
+    }
+    return modifiers
 }
 
 func NewVoiceSynthesizer(ctx context.Context, config VoiceConfig) (*VoiceSynthesizer, error) {
@@ -71,11 +99,31 @@ func NewVoiceSynthesizer(ctx context.Context, config VoiceConfig) (*VoiceSynthes
 }
 
 func (vs *VoiceSynthesizer) Synthesize(ctx context.Context, text string, emotion string) ([]byte, error) {
+    return vs.SynthesizeLang(ctx, text, emotion, "")
+}
+
+// SynthesizeLang speaks text with the voice configured for language; an
+// empty language uses the default voice.
+func (vs *VoiceSynthesizer) SynthesizeLang(ctx context.Context, text string, emotion string, language string) ([]byte, error) {
+    return vs.SynthesizeBlend(ctx, text, emotion, "", 0, language)
+}
+
+// SynthesizeBlend mixes the voice modifiers of two emotions, blend being the
+// secondary's share
+func (vs *VoiceSynthesizer) SynthesizeBlend(ctx context.Context, text string, emotion string, secondary string, blend float64, language string) ([]byte, error) {
     vs.mu.Lock()
     defer vs.mu.Unlock()
 
+    // Without an emotion, speak in the mood followed from the engine
+    if emotion == "" && vs.currentEmotion != "" {
+        emotion, secondary, blend = vs.currentEmotion, vs.currentSecondary, vs.currentBlend
+    }
+
     // Apply emotion modifiers
-    modifier := vs.emotionModifiers[emotion]
+    modifier := vs.emotionModifiers[emotionTaxonomy().Canonical(emotion)]
+    if secondary != "" && blend > 0 {
+        modifier = blendVoiceModifiers(modifier, vs.emotionModifiers[emotionTaxonomy().Canonical(secondary)], blend)
+    }
     vs.applyEmotionModifier(modifier)
 
     // Generate SSML with prosody tags
@@ -88,11 +136,7 @@ func (vs *VoiceSynthesizer) Synthesize(ctx context.Context, text string, emotion
                 Ssml: ssml,
             },
         },
-        Voice: &texttospeechpb.VoiceSelectionParams{
-            LanguageCode: vs.voiceConfig.Language,
-            SsmlGender:   vs.voiceConfig.Gender,
-            Name:         vs.voiceConfig.BaseModel,
-        },
+        Voice: vs.voiceFor(language),
         AudioConfig: &texttospeechpb.AudioConfig{
             AudioEncoding: vs.voiceConfig.AudioEncoding,
             SpeakingRate:  vs.speakingRate,
@@ -116,12 +160,99 @@ func (vs *VoiceSynthesizer) Synthesize(ctx context.Context, text string, emotion
     return processedAudio, nil
 }
 
+func (vs *VoiceSynthesizer) voiceFor(language string) *texttospeechpb.VoiceSelectionParams {
+    if voice, ok := vs.voiceConfig.Voices[language]; ok {
+        return &texttospeechpb.VoiceSelectionParams{
+            LanguageCode: voice.LanguageCode,
+            SsmlGender:   voice.Gender,
+            Name:         voice.Name,
+        }
+    }
+
+    // BaseModel names a voice for the default language only
+    if code, ok := defaultVoiceLanguageCodes[language]; ok && !strings.HasPrefix(vs.voiceConfig.Language, language) {
+        return &texttospeechpb.VoiceSelectionParams{
+            LanguageCode: code,
+            SsmlGender:   vs.voiceConfig.Gender,
+        }
+    }
+
+    return &texttospeechpb.VoiceSelectionParams{
+        LanguageCode: vs.voiceConfig.Language,
+        SsmlGender:   vs.voiceConfig.Gender,
+        Name:         vs.voiceConfig.BaseModel,
+    }
+}
+
 func (vs *VoiceSynthesizer) generateSSML(text string, emotion string) string {
     // Generate SSML with emotion-specific prosody and effects
     // Complex SSML generation logic here
     return ""
 }
 
+// ApplyEmotion sets the prosody for an emotion without speaking, for replays
+// and previews, and returns the modifier it applied
+func (vs *VoiceSynthesizer) ApplyEmotion(emotion string) VoiceModifier {
+    return vs.ApplyBlend(emotion, "", 0)
+}
+
+// ApplyBlend is ApplyEmotion for a mix of two emotions, blend being the
+// secondary's share
+func (vs *VoiceSynthesizer) ApplyBlend(emotion string, secondary string, blend float64) VoiceModifier {
+    vs.mu.Lock()
+    defer vs.mu.Unlock()
+
+    modifier := vs.emotionModifiers[emotionTaxonomy().Canonical(emotion)]
+    if secondary != "" && blend > 0 {
+        modifier = blendVoiceModifiers(modifier, vs.emotionModifiers[emotionTaxonomy().Canonical(secondary)], blend)
+    }
+    vs.applyEmotionModifier(modifier)
+    return modifier
+}
+
+// FollowEmotions tracks the emotion engine's mood for speech that doesn't
+// carry its own emotion, until ctx is done
+func (vs *VoiceSynthesizer) FollowEmotions(ctx context.Context, bus *EmotionBus) {
+    sub := bus.Subscribe(SubscribeOptions{
+        Types:  []EmotionEventType{EmotionChanged, MoodShifted},
+        Buffer: 4,
+        Policy: PolicyDropOldest,
+    })
+    defer sub.Unsubscribe()
+
+    for {
+        select {
+        case event, ok := <-sub.C:
+            if !ok {
+                return
+            }
+            vs.mu.Lock()
+            vs.currentEmotion = event.State.Primary
+            vs.currentSecondary = event.State.Secondary
+            vs.currentBlend = event.State.Blend
+            vs.mu.Unlock()
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// blendVoiceModifiers interpolates prosody; effect chains can't be mixed so
+// the dominant emotion's chain is kept
+func blendVoiceModifiers(a VoiceModifier, b VoiceModifier, blend float64) VoiceModifier {
+    blend = clamp(blend, 0, 1)
+    mixed := VoiceModifier{
+        PitchMod:    lerp(a.PitchMod, b.PitchMod, blend),
+        RateMod:     lerp(a.RateMod, b.RateMod, blend),
+        VolumeMod:   lerp(a.VolumeMod, b.VolumeMod, blend),
+        EffectChain: a.EffectChain,
+    }
+    if blend > 0.5 {
+        mixed.EffectChain = b.EffectChain
+    }
+    return mixed
+}
+
 func (vs *VoiceSynthesizer) applyEmotionModifier(modifier VoiceModifier) {
     vs.pitch = clamp(vs.pitch+modifier.PitchMod, vs.voiceConfig.PitchRange[0], vs.voiceConfig.PitchRange[1])
     vs.speakingRate = clamp(vs.speakingRate+modifier.RateMod, vs.voiceConfig.RateRange[0], vs.voiceConfig.RateRange[1])